@@ -0,0 +1,125 @@
+package main
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"log"
+	"net/http"
+	"strings"
+
+	"github.com/pkg/errors"
+	admissionv1beta1 "k8s.io/api/admission/v1beta1"
+	"k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+//placeholderNodePort replaces the NodePorts not allocated yet when validating,
+//the API server allocates them after the admission webhooks are called.
+const placeholderNodePort = 30000
+
+//validateService returns why the LoadBalancer Service ksvc would not be synced,
+//running the checks of sync without calling the load balancer API, but to
+//count the services of the namespace if the policy limits them.
+func validateService(ksvc v1.Service) error {
+	if !managedService(ksvc) {
+		return nil
+	}
+	ports, proto, err := getPortsProto(ksvc)
+	if err != nil {
+		return errors.Wrap(err, "create one Service for TCP and one for UDP")
+	}
+	for i, r := range ksvc.Spec.LoadBalancerSourceRanges {
+		if _, err := parsePeer(strings.TrimSpace(r)); err != nil {
+			return errors.Wrapf(err, "spec.loadBalancerSourceRanges[%d]", i)
+		}
+	}
+	if _, err := networkPolicies(ksvc, proto, ports); err != nil {
+		return err
+	}
+	if _, err := requestedIPs(ksvc); err != nil {
+		return err
+	}
+	if _, err := probeChecksEnabled(ksvc); err != nil {
+		return err
+	}
+
+	protoString := strings.ToLower(string(proto))
+	key := serviceKey(ksvc, protoString)
+	withPorts := ksvc
+	withPorts.Spec.Ports = append([]v1.ServicePort{}, ksvc.Spec.Ports...)
+	for i := range withPorts.Spec.Ports {
+		if withPorts.Spec.Ports[i].NodePort == 0 {
+			withPorts.Spec.Ports[i].NodePort = placeholderNodePort
+		}
+	}
+	if err := newlbcontrollerService(withPorts, key, protoString).Validate(); err != nil {
+		return errors.Wrap(err, "invalid load balancer service")
+	}
+
+	if np := currentPolicy().forNamespace(ksvc.Namespace); np != nil {
+		services := 0
+		if np.MaxServices > 0 {
+			services, err = countNamespaceServices(ksvc.Namespace, key)
+			if err != nil {
+				//the limit is enforced by sync anyway
+				log.Printf("WARNING: not checking the limit of services: %v\n", err)
+			}
+		}
+		if err := np.check(ksvc, proto, ports, services); err != nil {
+			return errors.Wrap(err, "rejected by the load balancer policy")
+		}
+	}
+	return nil
+}
+
+//admissionHandler is the ValidatingAdmissionWebhook rejecting the LoadBalancer
+//Services which the controller would not sync, with the reason.
+func admissionHandler(w http.ResponseWriter, r *http.Request) {
+	body, err := ioutil.ReadAll(r.Body)
+	if err != nil {
+		log.Println(err)
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	review := admissionv1beta1.AdmissionReview{}
+	if err := json.Unmarshal(body, &review); err != nil || review.Request == nil {
+		log.Printf("invalid AdmissionReview: %v\n", err)
+		http.Error(w, "invalid AdmissionReview", http.StatusBadRequest)
+		return
+	}
+
+	req := review.Request
+	response := &admissionv1beta1.AdmissionResponse{UID: req.UID, Allowed: true}
+	if req.Kind.Kind == "Service" && (req.Operation == admissionv1beta1.Create || req.Operation == admissionv1beta1.Update) {
+		ksvc := v1.Service{}
+		err := json.Unmarshal(req.Object.Raw, &ksvc)
+		if err == nil {
+			if ksvc.Namespace == "" {
+				ksvc.Namespace = req.Namespace
+			}
+			err = validateService(ksvc)
+		}
+		if err != nil {
+			log.Printf("rejecting Service %s/%s: %v\n", req.Namespace, req.Name, err)
+			response.Allowed = false
+			response.Result = &metav1.Status{
+				Status:  metav1.StatusFailure,
+				Message: err.Error(),
+				Reason:  metav1.StatusReasonInvalid,
+				Code:    http.StatusUnprocessableEntity,
+			}
+		}
+	}
+
+	//the response has the apiVersion of the request, v1beta1 and v1 are the same
+	review.Request = nil
+	review.Response = response
+	body, err = json.Marshal(&review)
+	if err != nil {
+		log.Println(err)
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	w.Write(body)
+}
@@ -0,0 +1,112 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	admissionv1beta1 "k8s.io/api/admission/v1beta1"
+	"k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+)
+
+func review(t *testing.T, ksvc v1.Service) *admissionv1beta1.AdmissionResponse {
+	raw, err := json.Marshal(ksvc)
+	if err != nil {
+		t.Fatal(err)
+	}
+	data, _ := json.Marshal(admissionv1beta1.AdmissionReview{
+		Request: &admissionv1beta1.AdmissionRequest{
+			UID:       "1234",
+			Kind:      metav1.GroupVersionKind{Version: "v1", Kind: "Service"},
+			Namespace: ksvc.Namespace,
+			Name:      ksvc.Name,
+			Operation: admissionv1beta1.Create,
+			Object:    runtime.RawExtension{Raw: raw},
+		},
+	})
+	rec := httptest.NewRecorder()
+	admissionHandler(rec, httptest.NewRequest("POST", "/validate", bytes.NewReader(data)))
+	if rec.Code != http.StatusOK {
+		t.Fatalf("admissionHandler() status = %d: %s", rec.Code, rec.Body)
+	}
+	ret := admissionv1beta1.AdmissionReview{}
+	if err := json.Unmarshal(rec.Body.Bytes(), &ret); err != nil || ret.Response == nil {
+		t.Fatalf("admissionHandler() response %s: %v", rec.Body, err)
+	}
+	if ret.Response.UID != "1234" {
+		t.Errorf("admissionHandler() UID = %s, want the one of the request", ret.Response.UID)
+	}
+	return ret.Response
+}
+
+func TestAdmission(t *testing.T) {
+	newTestAPI(t)
+
+	tests := []struct {
+		name    string
+		ksvc    func() v1.Service
+		message string
+	}{
+		{"valid", func() v1.Service {
+			//the NodePorts are not allocated yet
+			return newTestKService(v1.ServiceTypeLoadBalancer, v1.ServicePort{Port: 80})
+		}, ""},
+		{"NodePort", func() v1.Service {
+			return newTestKService(v1.ServiceTypeNodePort,
+				v1.ServicePort{Protocol: v1.ProtocolUDP, Port: 53}, v1.ServicePort{Protocol: v1.ProtocolTCP, Port: 53})
+		}, ""},
+		{"mixed protocols", func() v1.Service {
+			return newTestKService(v1.ServiceTypeLoadBalancer,
+				v1.ServicePort{Protocol: v1.ProtocolUDP, Port: 53}, v1.ServicePort{Protocol: v1.ProtocolTCP, Port: 53})
+		}, "both TCP and UDP"},
+		{"invalid annotation", func() v1.Service {
+			ksvc := newTestKService(v1.ServiceTypeLoadBalancer, v1.ServicePort{Port: 80})
+			ksvc.Annotations = map[string]string{annotationNetworkPolicy: "maybe"}
+			return ksvc
+		}, "invalid boolean"},
+		{"invalid probe health check", func() v1.Service {
+			ksvc := newTestKService(v1.ServiceTypeLoadBalancer, v1.ServicePort{Port: 80})
+			ksvc.Annotations = map[string]string{annotationProbeHealthCheck: "sometimes"}
+			return ksvc
+		}, annotationProbeHealthCheck},
+		{"invalid source range", func() v1.Service {
+			ksvc := newTestKService(v1.ServiceTypeLoadBalancer, v1.ServicePort{Port: 80})
+			ksvc.Spec.LoadBalancerSourceRanges = []string{"10.0.0.0/40"}
+			return ksvc
+		}, "spec.loadBalancerSourceRanges[0]"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			res := review(t, tt.ksvc())
+			if tt.message == "" {
+				if !res.Allowed {
+					t.Errorf("admissionHandler() rejected the Service: %v", res.Result)
+				}
+				return
+			}
+			if res.Allowed || res.Result == nil || !strings.Contains(res.Result.Message, tt.message) {
+				t.Errorf("admissionHandler() = %+v, want rejected with %q", res, tt.message)
+			}
+		})
+	}
+}
+
+func TestAdmissionPolicy(t *testing.T) {
+	newTestAPI(t)
+	p, err := loadPolicy(writeTestPolicy(t, testPolicy))
+	if err != nil {
+		t.Fatal(err)
+	}
+	lbpolicy.Store(p)
+	defer lbpolicy.Store((*policy)(nil))
+
+	res := review(t, newTestKService(v1.ServiceTypeLoadBalancer, v1.ServicePort{Port: 8080}))
+	if res.Allowed || !strings.Contains(res.Result.Message, "port 8080 not allowed") {
+		t.Errorf("admissionHandler() = %+v, want rejected by the policy", res)
+	}
+}
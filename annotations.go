@@ -0,0 +1,86 @@
+package main
+
+import (
+	"strconv"
+	"strings"
+
+	"github.com/pkg/errors"
+	"k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/util/validation"
+)
+
+//annotationPrefix is the prefix of all the Service annotations understood by the controller
+const annotationPrefix = "lbcontroller.uninett.no/"
+
+//Service annotations
+const (
+	//annotationNetworkPolicy tells if a NetworkPolicy should be generated for the service,
+	//overriding the netpol flag.
+	annotationNetworkPolicy = annotationPrefix + "networkpolicy"
+	//annotationNetworkPolicyName is the name of the NetworkPolicy of the service,
+	//overriding the netpol-name template.
+	annotationNetworkPolicyName = annotationPrefix + "networkpolicy-name"
+	//annotationNetworkPolicyLabels lists extra labels of the NetworkPolicy, comma
+	//separated key=value pairs, overriding the netpol-label flags with the same keys.
+	annotationNetworkPolicyLabels = annotationPrefix + "networkpolicy-labels"
+	//annotationPreserveClientIP tells that the load balancers forward the traffic
+	//with the client source IP, as for services with externalTrafficPolicy: Local.
+	annotationPreserveClientIP = annotationPrefix + "preserve-client-ip"
+	//annotationSourceRangesExcept lists CIDRs, comma separated, to exclude from the
+	//loadBalancerSourceRanges in the generated NetworkPolicy.
+	annotationSourceRangesExcept = annotationPrefix + "source-ranges-except"
+	//annotationLoadBalancerIPs lists the ingress IPs requested for the service, comma
+	//separated, at most one IPv4 and one IPv6, overriding spec.loadBalancerIP.
+	annotationLoadBalancerIPs = annotationPrefix + "load-balancer-ips"
+	//annotationProbeHealthCheck tells if the health check should be derived from the
+	//readiness probes of the pods, overriding the probe-health-checks flag.
+	annotationProbeHealthCheck = annotationPrefix + "probe-health-check"
+)
+
+//annotationBool parses a boolean annotation, set is false if the annotation is missing.
+func annotationBool(ksvc v1.Service, key string) (value, set bool, err error) {
+	s, ok := ksvc.Annotations[key]
+	if !ok {
+		return false, false, nil
+	}
+	value, err = strconv.ParseBool(strings.TrimSpace(s))
+	if err != nil {
+		return false, true, errors.Errorf("annotation %s: invalid boolean %q", key, s)
+	}
+	return value, true, nil
+}
+
+//annotationCIDRs parses an annotation with a comma separated list of IPs or CIDRs,
+//bare IPs are normalised as the load balancer peers are.
+func annotationCIDRs(ksvc v1.Service, key string) ([]string, error) {
+	s, ok := ksvc.Annotations[key]
+	if !ok {
+		return nil, nil
+	}
+	cidrs, err := parsePeers(s)
+	if err != nil {
+		return nil, errors.Wrapf(err, "annotation %s", key)
+	}
+	return cidrs, nil
+}
+
+//annotationLabels parses an annotation with a comma separated list of key=value
+//labels, the keys and values must be valid Kubernetes labels.
+func annotationLabels(ksvc v1.Service, key string) (map[string]string, error) {
+	s, ok := ksvc.Annotations[key]
+	if !ok || strings.TrimSpace(s) == "" {
+		return nil, nil
+	}
+	ret := map[string]string{}
+	for _, f := range strings.Split(s, ",") {
+		kv := strings.SplitN(strings.TrimSpace(f), "=", 2)
+		if len(kv) != 2 {
+			return nil, errors.Errorf("annotation %s: %q is not in key=value form", key, f)
+		}
+		if errs := append(validation.IsQualifiedName(kv[0]), validation.IsValidLabelValue(kv[1])...); len(errs) > 0 {
+			return nil, errors.Errorf("annotation %s: invalid label %q: %s", key, f, strings.Join(errs, ", "))
+		}
+		ret[kv[0]] = kv[1]
+	}
+	return ret, nil
+}
@@ -0,0 +1,74 @@
+package main
+
+import (
+	"time"
+
+	"github.com/UNINETT/lbcontroller/lbapi"
+	"k8s.io/api/core/v1"
+)
+
+//The calls to the API go through the endpoints, failing over between them as the controller does
+
+func apiListServices() (svcs []lbapi.Service, err error) {
+	err = endpoints.Do(func(url string) (err error) {
+		svcs, err = lbapi.ListServices(url, *token)
+		return err
+	})
+	return svcs, err
+}
+
+func apiGetService(name string) (svc lbapi.Service, found bool, err error) {
+	err = endpoints.Do(func(url string) (err error) {
+		svc, found, err = lbapi.GetService(name, url, *token)
+		return err
+	})
+	return svc, found, err
+}
+
+//apiSyncService creates or updates svc, without the fields set by the API
+//when svc was read from it: the timestamps, ingress and connections.
+func apiSyncService(svc lbapi.Service) (ingress []v1.LoadBalancerIngress, err error) {
+	svc.Metadata.CreatedAt, svc.Metadata.UpdatedAt = time.Time{}, time.Time{}
+	svc.Ingress, svc.Connections = nil, nil
+	err = endpoints.Do(func(url string) (err error) {
+		ingress, err = lbapi.SyncService(svc, url, *token)
+		return err
+	})
+	return ingress, err
+}
+
+func apiDeleteService(name string) error {
+	return endpoints.Do(func(url string) error {
+		return lbapi.DeleteService(name, url, *token)
+	})
+}
+
+func apiListFrontends() (fronts []lbapi.Frontend, err error) {
+	err = endpoints.Do(func(url string) (err error) {
+		fronts, err = lbapi.ListFrontends(url, *token)
+		return err
+	})
+	return fronts, err
+}
+
+func apiGetFrontend(name string) (f lbapi.Frontend, found bool, err error) {
+	err = endpoints.Do(func(url string) (err error) {
+		f, found, err = lbapi.GetFrontend(name, url, *token)
+		return err
+	})
+	return f, found, err
+}
+
+func apiSyncFrontend(f lbapi.Frontend) error {
+	return endpoints.Do(func(url string) error {
+		return lbapi.SyncFrontend(f, url, *token)
+	})
+}
+
+func apiListPools() (pools []lbapi.Pool, err error) {
+	err = endpoints.Do(func(url string) (err error) {
+		pools, err = lbapi.ListPools(url, *token)
+		return err
+	})
+	return pools, err
+}
@@ -0,0 +1,231 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"reflect"
+	"strings"
+	"time"
+
+	"github.com/UNINETT/lbcontroller/lbapi"
+	"github.com/ghodss/yaml"
+	"github.com/pkg/errors"
+)
+
+//bundleVersion is the version of the bundle format written by export,
+//import refuses bundles of other versions.
+const bundleVersion = 1
+
+//Conflict handling of import, when an object exists with a different configuration
+const (
+	conflictSkip      = "skip"
+	conflictOverwrite = "overwrite"
+	conflictFail      = "fail"
+)
+
+//bundle is a snapshot of the configuration of the load balancers
+type bundle struct {
+	Version    int              `json:"version"`
+	ExportedAt time.Time        `json:"exported_at"`
+	Endpoint   string           `json:"endpoint,omitempty"`
+	Services   []lbapi.Service  `json:"services"`
+	Frontends  []lbapi.Frontend `json:"frontends"`
+}
+
+//exportBundle writes the services and the frontends in the API to file, - for
+//the standard output, as YAML or JSON according to format.
+func exportBundle(file, format string) error {
+	svcs, err := apiListServices()
+	if err != nil {
+		return err
+	}
+	fronts, err := apiListFrontends()
+	if err != nil {
+		return err
+	}
+	b := bundle{
+		Version:    bundleVersion,
+		ExportedAt: time.Now().UTC(),
+		Endpoint:   strings.Join(endpoints.URLs(), ","),
+		Services:   svcs,
+		Frontends:  fronts,
+	}
+
+	var data []byte
+	if format == "json" {
+		data, err = json.MarshalIndent(b, "", "  ")
+		data = append(data, '\n')
+	} else {
+		data, err = yaml.Marshal(b)
+	}
+	if err != nil {
+		return errors.Wrap(err, "error encoding the bundle")
+	}
+
+	if file == "-" {
+		_, err = os.Stdout.Write(data)
+		return err
+	}
+	if err := ioutil.WriteFile(file, data, 0600); err != nil {
+		return errors.Wrapf(err, "error writing %s", file)
+	}
+	fmt.Fprintf(os.Stderr, "exported %d services and %d frontends to %s\n", len(svcs), len(fronts), file)
+	return nil
+}
+
+//readBundle reads a bundle, YAML or JSON, from file, - for the standard input
+func readBundle(file string) (bundle, error) {
+	b := bundle{}
+	var (
+		data []byte
+		err  error
+	)
+	if file == "-" {
+		data, err = ioutil.ReadAll(os.Stdin)
+	} else {
+		data, err = ioutil.ReadFile(file)
+	}
+	if err != nil {
+		return b, errors.Wrapf(err, "error reading %s", file)
+	}
+	if err := yaml.Unmarshal(data, &b); err != nil {
+		return b, errors.Wrapf(err, "error decoding %s", file)
+	}
+	if b.Version != bundleVersion {
+		return b, errors.Errorf("unsupported bundle version %d in %s, expected %d", b.Version, file, bundleVersion)
+	}
+	for _, svc := range b.Services {
+		if err := svc.Validate(); err != nil {
+			return b, errors.Wrapf(err, "service %s", svc.Metadata.Name)
+		}
+	}
+	for _, f := range b.Frontends {
+		if f.Metadata.Name == "" {
+			return b, errors.New("frontend without name")
+		}
+	}
+	return b, nil
+}
+
+//importBundle applies the bundle in file to the API. The frontends are applied
+//first, as the services refer to them. Objects missing in the API are created,
+//the ones with a different configuration are handled according to onConflict.
+//With fail nothing is applied if there is any conflict. The services owned,
+//in the API or in the bundle, by other clusters are skipped, see checkOwner.
+//The ingress of the services is assigned by the API and not imported.
+func importBundle(w io.Writer, file, onConflict string, dryRun bool) error {
+	b, err := readBundle(file)
+	if err != nil {
+		return err
+	}
+	curSvcs, err := apiListServices()
+	if err != nil {
+		return err
+	}
+	curFronts, err := apiListFrontends()
+	if err != nil {
+		return err
+	}
+	svcByName := map[string]lbapi.Service{}
+	for _, svc := range curSvcs {
+		svcByName[svc.Metadata.Name] = svc
+	}
+	frontByName := map[string]lbapi.Frontend{}
+	for _, f := range curFronts {
+		frontByName[f.Metadata.Name] = f
+	}
+
+	//state of each object: "create", "unchanged", "conflict" or "foreign"
+	frontState := make([]string, len(b.Frontends))
+	svcState := make([]string, len(b.Services))
+	conflicts := []string{}
+	for i, f := range b.Frontends {
+		frontState[i] = "create"
+		if cur, found := frontByName[f.Metadata.Name]; found {
+			frontState[i] = "unchanged"
+			if !reflect.DeepEqual(cur.Config, f.Config) {
+				frontState[i] = "conflict"
+				conflicts = append(conflicts, "frontend "+f.Metadata.Name)
+			}
+		}
+	}
+	foreign := map[string]error{}
+	for i, svc := range b.Services {
+		svcState[i] = "create"
+		cur, found := svcByName[svc.Metadata.Name]
+		if found {
+			svcState[i] = "unchanged"
+			if sameService(cur, svc) {
+				continue
+			}
+		}
+		err := checkOwner(svc)
+		if err == nil && found {
+			err = checkOwner(cur)
+		}
+		switch {
+		case err != nil:
+			svcState[i] = "foreign"
+			foreign[svc.Metadata.Name] = err
+		case found:
+			svcState[i] = "conflict"
+			conflicts = append(conflicts, "service "+svc.Metadata.Name)
+		}
+	}
+	if onConflict == conflictFail && len(conflicts) > 0 {
+		return errors.Errorf("%d objects differ from the bundle, nothing imported: %v", len(conflicts), conflicts)
+	}
+
+	prefix := ""
+	if dryRun {
+		prefix = "(dry run) "
+	}
+	//apply returns the message of an object in state, and if it must be applied
+	apply := func(state string) (string, bool) {
+		switch {
+		case state == "create":
+			return "created", true
+		case state == "conflict" && onConflict == conflictOverwrite:
+			return "overwritten", true
+		case state == "conflict":
+			return "skipped, differs from the bundle", false
+		}
+		return "unchanged", false
+	}
+	for i, f := range b.Frontends {
+		msg, do := apply(frontState[i])
+		if do && !dryRun {
+			f.Metadata.CreatedAt, f.Metadata.UpdatedAt = time.Time{}, time.Time{}
+			if err := apiSyncFrontend(f); err != nil {
+				return err
+			}
+		}
+		fmt.Fprintf(w, "%sfrontend %s %s\n", prefix, f.Metadata.Name, msg)
+	}
+	for i, svc := range b.Services {
+		msg, do := apply(svcState[i])
+		if err, ok := foreign[svc.Metadata.Name]; ok {
+			msg = fmt.Sprintf("skipped, %v", err)
+		}
+		if do && !dryRun {
+			if _, err := apiSyncService(svc); err != nil {
+				return err
+			}
+		}
+		fmt.Fprintf(w, "%sservice %s %s\n", prefix, svc.Metadata.Name, msg)
+	}
+	return nil
+}
+
+//sameService returns true if a and b have the same type, configuration and labels
+func sameService(a, b lbapi.Service) bool {
+	if len(a.Metadata.Labels) > 0 || len(b.Metadata.Labels) > 0 {
+		if !reflect.DeepEqual(a.Metadata.Labels, b.Metadata.Labels) {
+			return false
+		}
+	}
+	return a.Type == b.Type && reflect.DeepEqual(a.Config, b.Config)
+}
@@ -0,0 +1,193 @@
+package main
+
+import (
+	"bytes"
+	"io/ioutil"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/UNINETT/lbcontroller/lbapi"
+	"github.com/UNINETT/lbcontroller/test/lbcontrollertest"
+)
+
+const testToken = "testtoken"
+
+//useTestAPI starts a mock API and points lbctl to it
+func useTestAPI(t *testing.T) *lbcontrollertest.Server {
+	srv := lbcontrollertest.NewServer(lbcontrollertest.Options{Token: testToken})
+	oldEndpoints, oldToken := endpoints, token
+	useEndpoints(t, srv.URL)
+	token = new(string)
+	*token = testToken
+	t.Cleanup(func() {
+		srv.Close()
+		endpoints, token = oldEndpoints, oldToken
+	})
+	return srv
+}
+
+//useEndpoints points lbctl to urls
+func useEndpoints(t *testing.T, urls ...string) {
+	var err error
+	endpoints, err = lbapi.NewEndpoints(urls, lbapi.Failover, time.Minute)
+	if err != nil {
+		t.Fatal(err)
+	}
+}
+
+func newTestService(name string, nodePort int32) lbapi.Service {
+	return lbapi.Service{
+		Type:     lbapi.TCP,
+		Metadata: lbapi.Metadata{Name: name},
+		Config: lbapi.Config{
+			Method:   "least_conn",
+			Ports:    map[string]int32{"80": nodePort},
+			Backends: []lbapi.Backend{{Host: "node1.example.com", Addrs: []string{"10.0.0.1"}}},
+			Frontend: "web",
+		},
+	}
+}
+
+func TestExportImport(t *testing.T) {
+	src := useTestAPI(t)
+	src.PutFrontend(lbapi.Frontend{Metadata: lbapi.Metadata{Name: "web"}, Config: map[string]interface{}{"port": 443.0}})
+	src.PutService(newTestService("a", 30080))
+	src.PutService(newTestService("b", 30081))
+
+	for _, format := range []string{"yaml", "json"} {
+		t.Run(format, func(t *testing.T) {
+			file := filepath.Join(t.TempDir(), "bundle."+format)
+			useEndpoints(t, src.URL)
+			if err := exportBundle(file, format); err != nil {
+				t.Fatalf("exportBundle() error = %v", err)
+			}
+
+			dst := useTestAPI(t)
+			out := &bytes.Buffer{}
+			if err := importBundle(out, file, conflictFail, false); err != nil {
+				t.Fatalf("importBundle() error = %v", err)
+			}
+			for _, svc := range src.Services() {
+				dst.AssertService(t, svc.Metadata.Name, svc.Config)
+			}
+			if f, found := dst.Frontend("web"); !found || f.Config["port"] != 443.0 {
+				t.Errorf("frontend web = %v, %v after import", f, found)
+			}
+
+			//importing again changes nothing
+			dst.ResetRequests()
+			out.Reset()
+			if err := importBundle(out, file, conflictFail, false); err != nil {
+				t.Fatalf("importBundle() again error = %v", err)
+			}
+			if strings.Count(out.String(), "unchanged") != 3 {
+				t.Errorf("importBundle() again output:\n%s", out)
+			}
+			for _, r := range dst.Requests() {
+				if r.Method != "GET" {
+					t.Errorf("importBundle() again sent %s %s", r.Method, r.Path)
+				}
+			}
+		})
+	}
+}
+
+func TestImportConflicts(t *testing.T) {
+	srv := useTestAPI(t)
+	srv.PutService(newTestService("a", 30080))
+	srv.PutService(newTestService("b", 30081))
+	file := filepath.Join(t.TempDir(), "bundle.yaml")
+	if err := exportBundle(file, "yaml"); err != nil {
+		t.Fatalf("exportBundle() error = %v", err)
+	}
+
+	changed := newTestService("a", 30090)
+	reset := func() {
+		srv.PutService(changed)
+		lbapi.DeleteService("b", srv.URL, testToken)
+	}
+
+	reset()
+	if err := importBundle(&bytes.Buffer{}, file, conflictFail, false); err == nil {
+		t.Errorf("importBundle() with a conflict and fail should fail")
+	}
+	if _, found := srv.Service("b"); found {
+		t.Errorf("importBundle() with fail imported b despite the conflict")
+	}
+
+	reset()
+	if err := importBundle(&bytes.Buffer{}, file, conflictSkip, false); err != nil {
+		t.Fatalf("importBundle() skip error = %v", err)
+	}
+	srv.AssertService(t, "a", changed.Config)
+	srv.AssertService(t, "b", newTestService("b", 30081).Config)
+
+	reset()
+	out := &bytes.Buffer{}
+	if err := importBundle(out, file, conflictOverwrite, true); err != nil {
+		t.Fatalf("importBundle() dry run error = %v", err)
+	}
+	srv.AssertService(t, "a", changed.Config)
+	srv.AssertNoService(t, "b")
+	if !strings.Contains(out.String(), "(dry run) service a overwritten") {
+		t.Errorf("importBundle() dry run output:\n%s", out)
+	}
+
+	if err := importBundle(&bytes.Buffer{}, file, conflictOverwrite, false); err != nil {
+		t.Fatalf("importBundle() overwrite error = %v", err)
+	}
+	srv.AssertService(t, "a", newTestService("a", 30080).Config)
+	srv.AssertService(t, "b", newTestService("b", 30081).Config)
+}
+
+func TestReadBundleVersion(t *testing.T) {
+	file := filepath.Join(t.TempDir(), "bundle.yaml")
+	if err := ioutil.WriteFile(file, []byte("version: 2\nservices: []\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := readBundle(file); err == nil {
+		t.Errorf("readBundle() of version 2 should fail")
+	}
+}
+
+func TestImportOwners(t *testing.T) {
+	srv := useTestAPI(t)
+	useCluster(t, "nird", false)
+	drained := newOwnedService("a", "nird")
+	drained.SetBackendState("node1", lbapi.BackendDrain)
+	srv.PutService(drained)
+	srv.PutService(newOwnedService("b", "other"))
+	file := filepath.Join(t.TempDir(), "bundle.yaml")
+	if err := exportBundle(file, "yaml"); err != nil {
+		t.Fatalf("exportBundle() error = %v", err)
+	}
+
+	//the labels differ
+	srv.PutService(newOwnedService("a", "nird"))
+	changed := newOwnedService("b", "other")
+	changed.Config.Ports = map[string]int32{"80": 30090}
+	srv.PutService(changed)
+	if err := importBundle(&bytes.Buffer{}, file, conflictFail, false); err == nil {
+		t.Errorf("importBundle() with different labels and fail should fail")
+	}
+
+	out := &bytes.Buffer{}
+	if err := importBundle(out, file, conflictOverwrite, false); err != nil {
+		t.Fatalf("importBundle() overwrite error = %v", err)
+	}
+	if svc, _ := srv.Service("a"); len(svc.DrainedHosts()) != 1 || svc.Config.Backends[0].State != lbapi.BackendDrain {
+		t.Errorf("importBundle() did not restore the drained label, labels %v", svc.Metadata.Labels)
+	}
+	srv.AssertService(t, "b", changed.Config)
+	if !strings.Contains(out.String(), "service b skipped, service b is owned by cluster other") {
+		t.Errorf("importBundle() output:\n%s", out)
+	}
+
+	useCluster(t, "nird", true)
+	if err := importBundle(&bytes.Buffer{}, file, conflictOverwrite, false); err != nil {
+		t.Fatalf("importBundle() with --all-clusters error = %v", err)
+	}
+	srv.AssertService(t, "b", newOwnedService("b", "other").Config)
+}
@@ -0,0 +1,63 @@
+package main
+
+import (
+	"fmt"
+	"sort"
+	"text/tabwriter"
+
+	"github.com/UNINETT/lbcontroller/lbapi"
+	"github.com/pkg/errors"
+)
+
+//clusterInfo is a cluster owning services in the API
+type clusterInfo struct {
+	Name     string `json:"name"`
+	Services int    `json:"services"`
+}
+
+//listClusters lists the clusters owning the services, the services
+//without owner are counted under <none>.
+func listClusters() error {
+	svcs, err := apiListServices()
+	if err != nil {
+		return err
+	}
+	clusters := []clusterInfo{}
+	for name, n := range lbapi.Clusters(svcs) {
+		clusters = append(clusters, clusterInfo{Name: name, Services: n})
+	}
+	sort.Slice(clusters, func(i, j int) bool { return clusters[i].Name < clusters[j].Name })
+
+	return printObject(clusters, func(w *tabwriter.Writer) {
+		fmt.Fprintln(w, "CLUSTER\tSERVICES")
+		for _, c := range clusters {
+			fmt.Fprintf(w, "%s\t%d\n", orNone(c.Name), c.Services)
+		}
+	})
+}
+
+//listedServices returns the services of --cluster, or all of them if not
+//given or with --all-clusters
+func listedServices() ([]lbapi.Service, error) {
+	svcs, err := apiListServices()
+	if err != nil {
+		return nil, err
+	}
+	if *cluster != "" && !*all {
+		svcs = lbapi.ClusterServices(svcs, *cluster)
+	}
+	return svcs, nil
+}
+
+//checkOwner returns an error if svc is owned by a cluster other than
+//--cluster, unless --all-clusters is given. Without --cluster only the
+//services without owner can be changed.
+func checkOwner(svc lbapi.Service) error {
+	if *all {
+		return nil
+	}
+	if err := svc.CheckOwner(*cluster); err != nil {
+		return errors.Errorf("%v, use --all-clusters to change it", err)
+	}
+	return nil
+}
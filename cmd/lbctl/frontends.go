@@ -0,0 +1,57 @@
+package main
+
+import (
+	"fmt"
+	"text/tabwriter"
+
+	"github.com/UNINETT/lbcontroller/lbapi"
+	"github.com/pkg/errors"
+)
+
+func printFrontends(fronts []lbapi.Frontend, obj interface{}) error {
+	return printObject(obj, func(w *tabwriter.Writer) {
+		fmt.Fprintln(w, "NAME\tAGE\tUPDATED")
+		for _, f := range fronts {
+			fmt.Fprintf(w, "%s\t%s\t%s\n",
+				f.Metadata.Name,
+				formatAge(f.Metadata.CreatedAt),
+				formatAge(f.Metadata.UpdatedAt),
+			)
+		}
+	})
+}
+
+func listFrontends() error {
+	fronts, err := apiListFrontends()
+	if err != nil {
+		return err
+	}
+	return printFrontends(fronts, fronts)
+}
+
+func getFrontend(name string) error {
+	f, found, err := apiGetFrontend(name)
+	if err != nil {
+		return err
+	}
+	if !found {
+		return errors.Errorf("frontend %s not found", name)
+	}
+	return printFrontends([]lbapi.Frontend{f}, f)
+}
+
+func getIngress(name string) error {
+	svc, found, err := apiGetService(name)
+	if err != nil {
+		return err
+	}
+	if !found {
+		return errors.Errorf("service %s not found", name)
+	}
+	return printObject(svc.Ingress, func(w *tabwriter.Writer) {
+		fmt.Fprintln(w, "IP\tHOSTNAME")
+		for _, in := range svc.Ingress {
+			fmt.Fprintf(w, "%s\t%s\n", orNone(in.IP), orNone(in.Hostname))
+		}
+	})
+}
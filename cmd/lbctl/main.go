@@ -0,0 +1,105 @@
+//lbctl is a command line tool to operate the Uninett load balancer API.
+package main
+
+import (
+	"os"
+	"strings"
+
+	"github.com/UNINETT/lbcontroller/lbapi"
+	"gopkg.in/alecthomas/kingpin.v2"
+)
+
+//endpoints are the API endpoints of --endpoint, the calls fail over between them
+var endpoints *lbapi.Endpoints
+
+var (
+	app          = kingpin.New("lbctl", "Operate the Uninett load balancer API.")
+	endpoint     = app.Flag("endpoint", "The load balancer controller API endpoints, comma separated, e.g. one per site").Required().Envar("LBC_ENDPOINT").String()
+	endpointMode = app.Flag("endpoint-mode", "How the endpoints are selected, failover uses the first healthy one").Default(lbapi.Failover).Envar("LBC_ENDPOINT_MODE").Enum(lbapi.Failover, lbapi.RoundRobin)
+	endpointDown = app.Flag("endpoint-backoff", "How long an endpoint failing with a connection error, a 5xx or 429 answer is avoided").Default("30s").Envar("LBC_ENDPOINT_BACKOFF").Duration()
+	token        = app.Flag("token", "Authentication token to access the load balancer API").Required().Envar("LBC_TOKEN").String()
+	output       = app.Flag("output", "Output format").Short('o').Default("table").Enum("table", "json", "yaml")
+	cluster      = app.Flag("cluster", "The cluster of the services, only them and the services without owner are changed").Envar("LBC_CLUSTER_NAME").String()
+	all          = app.Flag("all-clusters", "List and change the services of all the clusters").Bool()
+
+	servicesCmd       = app.Command("services", "Manage the load balanced services.").Alias("svc")
+	servicesList      = servicesCmd.Command("list", "List the services.").Alias("ls")
+	servicesGet       = servicesCmd.Command("get", "Show a service.")
+	servicesGetName   = servicesGet.Arg("name", "Name of the service").Required().String()
+	servicesApply     = servicesCmd.Command("apply", "Create or update the services in a file.")
+	servicesApplyFile = servicesApply.Flag("filename", "YAML or JSON file with a service or a list of services, - for the standard input").Short('f').Required().String()
+	servicesDelete    = servicesCmd.Command("delete", "Delete services.").Alias("rm")
+	servicesDelNames  = servicesDelete.Arg("names", "Names of the services").Required().Strings()
+
+	frontendsCmd     = app.Command("frontends", "Inspect the frontends.").Alias("fe")
+	frontendsList    = frontendsCmd.Command("list", "List the frontends.").Alias("ls")
+	frontendsGet     = frontendsCmd.Command("get", "Show a frontend.")
+	frontendsGetName = frontendsGet.Arg("name", "Name of the frontend").Required().String()
+
+	ingressCmd     = app.Command("ingress", "Inspect the ingress of the services.")
+	ingressGet     = ingressCmd.Command("get", "Show the ingress of a service.")
+	ingressGetName = ingressGet.Arg("name", "Name of the service").Required().String()
+
+	poolsCmd  = app.Command("pools", "Inspect the pools of ingress IPs.")
+	poolsList = poolsCmd.Command("list", "List the pools and their usage.").Alias("ls")
+
+	clustersCmd  = app.Command("clusters", "Inspect the clusters owning the services.")
+	clustersList = clustersCmd.Command("list", "List the clusters and the number of their services.").Alias("ls")
+
+	nodesCmd          = app.Command("nodes", "Maintain the nodes of the backends.").Alias("node")
+	nodesDrain        = nodesCmd.Command("drain", "Drain the backends on a node in all the services, waiting for their connections to end.")
+	nodesDrainName    = nodesDrain.Arg("node", "Name or host name of the node").Required().String()
+	nodesDrainTimeout = nodesDrain.Flag("timeout", "How long to wait for the connections to end, 0 does not wait").Default("5m").Duration()
+	nodesUndrain      = nodesCmd.Command("undrain", "Put the backends on a node back in all the services.")
+	nodesUndrainName  = nodesUndrain.Arg("node", "Name or host name of the node").Required().String()
+	nodesStatus       = nodesCmd.Command("status", "Show the services including a node, or all the nodes, and their state.")
+	nodesStatusName   = nodesStatus.Arg("node", "Name or host name of the node").String()
+
+	exportCmd        = app.Command("export", "Export the services and the frontends to a bundle, for backup or migration.")
+	exportFile       = exportCmd.Flag("filename", "File to write the bundle to, - for the standard output").Short('f').Default("-").String()
+	exportFormat     = exportCmd.Flag("format", "Format of the bundle").Default("yaml").Enum("yaml", "json")
+	importCmd        = app.Command("import", "Import the services and the frontends of a bundle.")
+	importFile       = importCmd.Flag("filename", "YAML or JSON bundle written by export, - for the standard input").Short('f').Required().String()
+	importOnConflict = importCmd.Flag("on-conflict", "What to do with the objects that exist with a different configuration").Default(conflictFail).Enum(conflictSkip, conflictOverwrite, conflictFail)
+	importDryRun     = importCmd.Flag("dry-run", "Only print what would be imported").Bool()
+)
+
+func main() {
+	cmd := kingpin.MustParse(app.Parse(os.Args[1:]))
+
+	var err error
+	endpoints, err = lbapi.NewEndpoints(strings.Split(*endpoint, ","), *endpointMode, *endpointDown)
+	app.FatalIfError(err, "")
+
+	switch cmd {
+	case servicesList.FullCommand():
+		err = listServices()
+	case servicesGet.FullCommand():
+		err = getService(*servicesGetName)
+	case servicesApply.FullCommand():
+		err = applyServices(*servicesApplyFile)
+	case servicesDelete.FullCommand():
+		err = deleteServices(*servicesDelNames)
+	case frontendsList.FullCommand():
+		err = listFrontends()
+	case frontendsGet.FullCommand():
+		err = getFrontend(*frontendsGetName)
+	case ingressGet.FullCommand():
+		err = getIngress(*ingressGetName)
+	case poolsList.FullCommand():
+		err = listPools()
+	case clustersList.FullCommand():
+		err = listClusters()
+	case nodesDrain.FullCommand():
+		err = drainNode(os.Stdout, *nodesDrainName, *nodesDrainTimeout)
+	case nodesUndrain.FullCommand():
+		err = undrainNode(os.Stdout, *nodesUndrainName)
+	case nodesStatus.FullCommand():
+		err = nodeStatus(*nodesStatusName)
+	case exportCmd.FullCommand():
+		err = exportBundle(*exportFile, *exportFormat)
+	case importCmd.FullCommand():
+		err = importBundle(os.Stdout, *importFile, *importOnConflict, *importDryRun)
+	}
+	app.FatalIfError(err, "%s", cmd)
+}
@@ -0,0 +1,173 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"strings"
+	"text/tabwriter"
+	"time"
+
+	"github.com/UNINETT/lbcontroller/lbapi"
+	"github.com/pkg/errors"
+)
+
+//drainPoll is how often the connections of a draining node are checked
+var drainPoll = 5 * time.Second
+
+//nodeBackend is a backend of a node in a service
+type nodeBackend struct {
+	Host        string `json:"host"`
+	Service     string `json:"service"`
+	Cluster     string `json:"cluster,omitempty"`
+	State       string `json:"state,omitempty"`
+	Connections *int   `json:"connections,omitempty"` //nil if not reported by the API
+}
+
+//setNodeState sets state on the backends of node in the services that can be
+//changed, see checkOwner, and returns the names of the services including node.
+//The services of the other clusters are skipped. The services already in state,
+//e.g. drained by the controller, are updated if LabelDrained must record it.
+//The services failing to update do not stop the others, they are all reported
+//in the error.
+func setNodeState(w io.Writer, node, state string) ([]string, error) {
+	svcs, err := apiListServices()
+	if err != nil {
+		return nil, err
+	}
+	names := []string{}
+	failed := []string{}
+	for _, svc := range svcs {
+		found, changed := false, false
+		for _, b := range svc.Config.Backends {
+			if lbapi.MatchHost(b.Host, node) {
+				found = true
+				changed = changed || b.State != state
+			}
+		}
+		if !found {
+			continue
+		}
+		if err := checkOwner(svc); err != nil {
+			fmt.Fprintf(w, "service %s skipped: %v\n", svc.Metadata.Name, err)
+			continue
+		}
+		drained := svc.Metadata.Labels[lbapi.LabelDrained]
+		svc.SetBackendState(node, state)
+		names = append(names, svc.Metadata.Name)
+		if !changed && svc.Metadata.Labels[lbapi.LabelDrained] == drained {
+			continue
+		}
+		if _, err := apiSyncService(svc); err != nil {
+			failed = append(failed, fmt.Sprintf("service %s: %v", svc.Metadata.Name, err))
+			continue
+		}
+		fmt.Fprintf(w, "service %s: node %s %s\n", svc.Metadata.Name, node, formatState(state))
+	}
+	if len(names) == 0 {
+		return nil, errors.Errorf("no service that can be changed has backends on node %s", node)
+	}
+	if len(failed) > 0 {
+		return names, errors.Errorf("error updating %d of %d services, node %s is not %s in them:\n%s",
+			len(failed), len(names), node, formatState(state), strings.Join(failed, "\n"))
+	}
+	return names, nil
+}
+
+//drainNode drains node in the services and waits until timeout for
+//its connections to end. If the API does not report the connections it waits
+//for the whole timeout.
+func drainNode(w io.Writer, node string, timeout time.Duration) error {
+	names, err := setNodeState(w, node, lbapi.BackendDrain)
+	if err != nil || timeout <= 0 {
+		return err
+	}
+
+	deadline := time.Now().Add(timeout)
+	for {
+		conns, reported, err := nodeConnections(node, names)
+		if err != nil {
+			return err
+		}
+		if reported && conns == 0 {
+			fmt.Fprintf(w, "node %s drained\n", node)
+			return nil
+		}
+		left := time.Until(deadline)
+		if left <= 0 {
+			if reported {
+				return errors.Errorf("%d connections left on node %s after %s", conns, node, timeout)
+			}
+			fmt.Fprintf(w, "node %s drained for %s, the API does not report the connections\n", node, timeout)
+			return nil
+		}
+		if left > drainPoll {
+			left = drainPoll
+		}
+		time.Sleep(left)
+	}
+}
+
+//undrainNode puts node back in the services
+func undrainNode(w io.Writer, node string) error {
+	_, err := setNodeState(w, node, "")
+	return err
+}
+
+//nodeConnections returns the live connections of node in the services names,
+//reported is false if the API reports none.
+func nodeConnections(node string, names []string) (conns int, reported bool, err error) {
+	for _, name := range names {
+		svc, found, err := apiGetService(name)
+		if err != nil {
+			return 0, false, err
+		}
+		if !found || svc.Connections == nil {
+			continue
+		}
+		reported = true
+		for host, n := range svc.Connections {
+			if lbapi.MatchHost(host, node) {
+				conns += n
+			}
+		}
+	}
+	return conns, reported, nil
+}
+
+//nodeStatus lists the backends of node, or of all the nodes if empty, in the services of --cluster
+func nodeStatus(node string) error {
+	svcs, err := listedServices()
+	if err != nil {
+		return err
+	}
+	backends := []nodeBackend{}
+	for _, svc := range svcs {
+		for _, b := range svc.Config.Backends {
+			if node != "" && !lbapi.MatchHost(b.Host, node) {
+				continue
+			}
+			nb := nodeBackend{Host: b.Host, Service: svc.Metadata.Name, Cluster: svc.Cluster(), State: b.State}
+			if n, ok := svc.Connections[b.Host]; ok {
+				nb.Connections = &n
+			}
+			backends = append(backends, nb)
+		}
+	}
+	return printObject(backends, func(w *tabwriter.Writer) {
+		fmt.Fprintln(w, "HOST\tSERVICE\tCLUSTER\tSTATE\tCONNECTIONS")
+		for _, nb := range backends {
+			conns := "-"
+			if nb.Connections != nil {
+				conns = fmt.Sprint(*nb.Connections)
+			}
+			fmt.Fprintf(w, "%s\t%s\t%s\t%s\t%s\n", nb.Host, nb.Service, orNone(nb.Cluster), formatState(nb.State), conns)
+		}
+	})
+}
+
+func formatState(state string) string {
+	if state == "" {
+		return "up"
+	}
+	return state
+}
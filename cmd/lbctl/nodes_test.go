@@ -0,0 +1,140 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"net/http"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/UNINETT/lbcontroller/lbapi"
+	"github.com/UNINETT/lbcontroller/test/lbcontrollertest"
+)
+
+func TestDrainNode(t *testing.T) {
+	srv := useTestAPI(t)
+	oldPoll := drainPoll
+	drainPoll = 10 * time.Millisecond
+	defer func() { drainPoll = oldPoll }()
+
+	for _, svc := range []lbapi.Service{newTestService("a", 30080), newTestService("b", 30081)} {
+		svc.Config.Backends = append(svc.Config.Backends, lbapi.Backend{Host: "node2.example.com", Addrs: []string{"10.0.0.2"}})
+		if err := srv.PutService(svc); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	//the API does not report the connections, wait for the timeout
+	out := &bytes.Buffer{}
+	if err := drainNode(out, "node1", 20*time.Millisecond); err != nil {
+		t.Fatalf("drainNode() error = %v", err)
+	}
+	for _, name := range []string{"a", "b"} {
+		svc, _ := srv.Service(name)
+		if svc.Config.Backends[0].State != lbapi.BackendDrain || svc.Config.Backends[1].State != "" {
+			t.Errorf("service %s backends = %+v, want node1 drained", name, svc.Config.Backends)
+		}
+	}
+	if !strings.Contains(out.String(), "does not report the connections") {
+		t.Errorf("drainNode() output = %q", out.String())
+	}
+
+	//connections left after the timeout
+	srv.SetConnections("a", "node1.example.com", 3)
+	srv.ResetRequests()
+	if err := drainNode(&bytes.Buffer{}, "node1", 20*time.Millisecond); err == nil || !strings.Contains(err.Error(), "3 connections left") {
+		t.Errorf("drainNode() error = %v, want 3 connections left", err)
+	}
+	for _, r := range srv.Requests() {
+		if r.Method == "PUT" {
+			t.Errorf("drainNode() updated %s, already drained", r.Service)
+		}
+	}
+
+	srv.SetConnections("a", "node1.example.com", 0)
+	out.Reset()
+	if err := drainNode(out, "node1", time.Second); err != nil || !strings.Contains(out.String(), "node node1 drained\n") {
+		t.Errorf("drainNode() = %v, output %q, want drained", err, out.String())
+	}
+
+	if err := drainNode(&bytes.Buffer{}, "node3", 0); err == nil {
+		t.Errorf("drainNode() of a node without backends should fail")
+	}
+
+	if err := undrainNode(&bytes.Buffer{}, "node1.example.com"); err != nil {
+		t.Fatalf("undrainNode() error = %v", err)
+	}
+	svc, _ := srv.Service("a")
+	if svc.Config.Backends[0].State != "" || len(svc.DrainedHosts()) != 0 {
+		t.Errorf("undrainNode() backends = %+v, labels %v", svc.Config.Backends, svc.Metadata.Labels)
+	}
+}
+
+func TestSetNodeStateFailures(t *testing.T) {
+	srv := useTestAPI(t)
+	autoDrained := newTestService("b", 30081)
+	autoDrained.Config.Backends[0].State = lbapi.BackendDrain
+	for _, svc := range []lbapi.Service{newTestService("a", 30080), autoDrained, newTestService("c", 30082)} {
+		if err := srv.PutService(svc); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := srv.AddFault(lbcontrollertest.Fault{Service: "a", ErrorRate: 1, Status: 503}); err != nil {
+		t.Fatal(err)
+	}
+
+	out := &bytes.Buffer{}
+	names, err := setNodeState(out, "node1", lbapi.BackendDrain)
+	if err == nil || !strings.Contains(err.Error(), "error updating 1 of 3 services") || !strings.Contains(err.Error(), "service a:") {
+		t.Errorf("setNodeState() error = %v, want service a failed", err)
+	}
+	if len(names) != 3 {
+		t.Errorf("setNodeState() = %v, want the 3 services", names)
+	}
+	for _, name := range []string{"b", "c"} {
+		svc, _ := srv.Service(name)
+		if svc.Config.Backends[0].State != lbapi.BackendDrain || len(svc.DrainedHosts()) != 1 {
+			t.Errorf("service %s backends = %+v, labels %v, want node1 drained by the operator", name, svc.Config.Backends, svc.Metadata.Labels)
+		}
+		if !strings.Contains(out.String(), "service "+name+": node node1 drain") {
+			t.Errorf("setNodeState() output = %q, want %s changed", out, name)
+		}
+	}
+	if svc, _ := srv.Service("a"); svc.Config.Backends[0].State != "" {
+		t.Errorf("service a drained despite the failure")
+	}
+}
+
+func TestSetNodeStateReadOnlyFields(t *testing.T) {
+	srv := useTestAPI(t)
+	if err := srv.PutService(newTestService("a", 30080)); err != nil {
+		t.Fatal(err)
+	}
+	if err := srv.SetConnections("a", "node1", 3); err != nil {
+		t.Fatal(err)
+	}
+	srv.ResetRequests()
+
+	if _, err := setNodeState(&bytes.Buffer{}, "node1", lbapi.BackendDrain); err != nil {
+		t.Fatalf("setNodeState() error = %v", err)
+	}
+	//the fields set by the API are not sent back
+	puts := 0
+	for _, r := range srv.Requests() {
+		if r.Method != http.MethodPut {
+			continue
+		}
+		puts++
+		svc := lbapi.Service{}
+		if err := json.Unmarshal(r.Body, &svc); err != nil {
+			t.Fatal(err)
+		}
+		if svc.Connections != nil || svc.Ingress != nil || !svc.Metadata.CreatedAt.IsZero() || !svc.Metadata.UpdatedAt.IsZero() {
+			t.Errorf("setNodeState() sent %s, want no connections, ingress nor timestamps", r.Body)
+		}
+	}
+	if puts != 1 {
+		t.Errorf("setNodeState() sent %d PUT requests, want 1", puts)
+	}
+}
@@ -0,0 +1,105 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+	"text/tabwriter"
+	"time"
+
+	"github.com/UNINETT/lbcontroller/lbapi"
+	"github.com/ghodss/yaml"
+	"k8s.io/api/core/v1"
+)
+
+//printObject prints obj as JSON or YAML according to the output flag,
+//or as a table written by printTable.
+func printObject(obj interface{}, printTable func(w *tabwriter.Writer)) error {
+	switch *output {
+	case "json":
+		data, err := json.MarshalIndent(obj, "", "  ")
+		if err != nil {
+			return err
+		}
+		_, err = fmt.Fprintln(os.Stdout, string(data))
+		return err
+	case "yaml":
+		data, err := yaml.Marshal(obj)
+		if err != nil {
+			return err
+		}
+		_, err = os.Stdout.Write(data)
+		return err
+	default:
+		w := tabwriter.NewWriter(os.Stdout, 0, 8, 2, ' ', 0)
+		printTable(w)
+		return w.Flush()
+	}
+}
+
+//formatPorts formats the ports of a service configuration as port->nodeport
+func formatPorts(ports map[string]int32) string {
+	keys := make([]string, 0, len(ports))
+	for p := range ports {
+		keys = append(keys, p)
+	}
+	sort.Slice(keys, func(i, j int) bool {
+		a, _ := strconv.Atoi(keys[i])
+		b, _ := strconv.Atoi(keys[j])
+		return a < b
+	})
+	ret := make([]string, 0, len(ports))
+	for _, p := range keys {
+		ret = append(ret, fmt.Sprintf("%s->%d", p, ports[p]))
+	}
+	return strings.Join(ret, ",")
+}
+
+func formatIngress(ingress []v1.LoadBalancerIngress) string {
+	ret := []string{}
+	for _, in := range ingress {
+		if in.IP != "" {
+			ret = append(ret, in.IP)
+		}
+		if in.Hostname != "" {
+			ret = append(ret, in.Hostname)
+		}
+	}
+	return orNone(strings.Join(ret, ","))
+}
+
+func formatHosts(backends []lbapi.Backend) string {
+	ret := make([]string, 0, len(backends))
+	for _, b := range backends {
+		ret = append(ret, b.Host)
+	}
+	return orNone(strings.Join(ret, ","))
+}
+
+//formatAge formats the time elapsed since t as kubectl does
+func formatAge(t time.Time) string {
+	if t.IsZero() {
+		return "<unknown>"
+	}
+	d := time.Since(t)
+	switch {
+	case d < time.Minute:
+		return fmt.Sprintf("%ds", int(d.Seconds()))
+	case d < time.Hour:
+		return fmt.Sprintf("%dm", int(d.Minutes()))
+	case d < 48*time.Hour:
+		return fmt.Sprintf("%dh", int(d.Hours()))
+	default:
+		return fmt.Sprintf("%dd", int(d.Hours()/24))
+	}
+}
+
+func orNone(s string) string {
+	if s == "" {
+		return "<none>"
+	}
+	return s
+}
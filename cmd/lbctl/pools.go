@@ -0,0 +1,30 @@
+package main
+
+import (
+	"fmt"
+	"strings"
+	"text/tabwriter"
+)
+
+func listPools() error {
+	pools, err := apiListPools()
+	if err != nil {
+		return err
+	}
+	return printObject(pools, func(w *tabwriter.Writer) {
+		fmt.Fprintln(w, "NAME\tRANGES\tNAMESPACES\tCLASSES\tUSAGE")
+		for _, p := range pools {
+			usage := []string{}
+			for _, u := range p.Usage {
+				usage = append(usage, fmt.Sprintf("%s %d/%d", u.Family, u.Used, u.Size))
+			}
+			fmt.Fprintf(w, "%s\t%s\t%s\t%s\t%s\n",
+				p.Name,
+				strings.Join(p.Ranges, ","),
+				orNone(strings.Join(p.Namespaces, ",")),
+				orNone(strings.Join(p.Classes, ",")),
+				orNone(strings.Join(usage, ",")),
+			)
+		}
+	})
+}
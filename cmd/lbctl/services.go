@@ -0,0 +1,153 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"regexp"
+	"text/tabwriter"
+
+	"github.com/UNINETT/lbcontroller/lbapi"
+	"github.com/ghodss/yaml"
+	"github.com/pkg/errors"
+)
+
+func printServices(svcs []lbapi.Service, obj interface{}) error {
+	return printObject(obj, func(w *tabwriter.Writer) {
+		fmt.Fprintln(w, "NAME\tCLUSTER\tTYPE\tPORTS\tBACKENDS\tINGRESS\tAGE")
+		for _, svc := range svcs {
+			fmt.Fprintf(w, "%s\t%s\t%s\t%s\t%s\t%s\t%s\n",
+				svc.Metadata.Name,
+				orNone(svc.Cluster()),
+				svc.Type,
+				orNone(formatPorts(svc.Config.Ports)),
+				formatHosts(svc.Config.Backends),
+				formatIngress(svc.Ingress),
+				formatAge(svc.Metadata.CreatedAt),
+			)
+		}
+	})
+}
+
+//listServices lists the services of --cluster, or all of them
+func listServices() error {
+	svcs, err := listedServices()
+	if err != nil {
+		return err
+	}
+	return printServices(svcs, svcs)
+}
+
+func getService(name string) error {
+	svc, found, err := apiGetService(name)
+	if err != nil {
+		return err
+	}
+	if !found {
+		return errors.Errorf("service %s not found", name)
+	}
+	return printServices([]lbapi.Service{svc}, svc)
+}
+
+//applyServices creates or updates the services in file, none if any is, or
+//would be, owned by another cluster
+func applyServices(file string) error {
+	var (
+		data []byte
+		err  error
+	)
+	if file == "-" {
+		data, err = ioutil.ReadAll(os.Stdin)
+	} else {
+		data, err = ioutil.ReadFile(file)
+	}
+	if err != nil {
+		return errors.Wrapf(err, "error reading %s", file)
+	}
+	svcs, err := decodeServices(data)
+	if err != nil {
+		return errors.Wrapf(err, "error decoding %s", file)
+	}
+	for _, svc := range svcs {
+		if err := svc.Validate(); err != nil {
+			return errors.Wrapf(err, "service %s", svc.Metadata.Name)
+		}
+		if err := checkOwner(svc); err != nil {
+			return err
+		}
+		cur, found, err := apiGetService(svc.Metadata.Name)
+		if err != nil {
+			return err
+		}
+		if found {
+			if err := checkOwner(cur); err != nil {
+				return err
+			}
+		}
+	}
+	for _, svc := range svcs {
+		ingress, err := apiSyncService(svc)
+		if err != nil {
+			return err
+		}
+		fmt.Printf("service %s configured, ingress %s\n", svc.Metadata.Name, formatIngress(ingress))
+	}
+	return nil
+}
+
+//deleteServices deletes the services names, none if any is owned by another cluster
+func deleteServices(names []string) error {
+	for _, name := range names {
+		svc, found, err := apiGetService(name)
+		if err != nil {
+			return err
+		}
+		if !found {
+			return errors.Errorf("service %s not found", name)
+		}
+		if err := checkOwner(svc); err != nil {
+			return err
+		}
+	}
+	for _, name := range names {
+		if err := apiDeleteService(name); err != nil {
+			return err
+		}
+		fmt.Printf("service %s deleted\n", name)
+	}
+	return nil
+}
+
+var yamlSeparator = regexp.MustCompile(`(?m)^---\s*$`)
+
+//decodeServices decodes the services in data, YAML or JSON, either single
+//services, possibly in several YAML documents, or lists of services.
+func decodeServices(data []byte) ([]lbapi.Service, error) {
+	ret := []lbapi.Service{}
+	for _, doc := range yamlSeparator.Split(string(data), -1) {
+		js, err := yaml.YAMLToJSON([]byte(doc))
+		if err != nil {
+			return nil, err
+		}
+		js = bytes.TrimSpace(js)
+		switch {
+		case len(js) == 0 || bytes.Equal(js, []byte("null")):
+			continue
+		case js[0] == '[':
+			var svcs []lbapi.Service
+			if err := json.Unmarshal(js, &svcs); err != nil {
+				return nil, err
+			}
+			ret = append(ret, svcs...)
+		default:
+			var svc lbapi.Service
+			if err := json.Unmarshal(js, &svc); err != nil {
+				return nil, err
+			}
+			ret = append(ret, svc)
+		}
+	}
+	return ret, nil
+}
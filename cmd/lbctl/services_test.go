@@ -0,0 +1,136 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"io/ioutil"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/UNINETT/lbcontroller/lbapi"
+)
+
+func TestDecodeServices(t *testing.T) {
+	tests := []struct {
+		name  string
+		data  string
+		names []string
+	}{
+		{"JSON", `{"type": "tcp", "metadata": {"name": "a"}}`, []string{"a"}},
+		{"JSON list", `[{"metadata": {"name": "a"}}, {"metadata": {"name": "b"}}]`, []string{"a", "b"}},
+		{"YAML documents", "metadata:\n  name: a\n---\n- metadata:\n    name: b\n- metadata:\n    name: c\n---\n", []string{"a", "b", "c"}},
+		{"empty", "", []string{}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			svcs, err := decodeServices([]byte(tt.data))
+			if err != nil {
+				t.Fatalf("decodeServices() error = %v", err)
+			}
+			if len(svcs) != len(tt.names) {
+				t.Fatalf("decodeServices() = %d services, want %d", len(svcs), len(tt.names))
+			}
+			for i, svc := range svcs {
+				if svc.Metadata.Name != tt.names[i] {
+					t.Errorf("decodeServices()[%d] = %s, want %s", i, svc.Metadata.Name, tt.names[i])
+				}
+			}
+		})
+	}
+
+	if _, err := decodeServices([]byte("metadata: [")); err == nil {
+		t.Errorf("decodeServices() of invalid YAML should fail")
+	}
+}
+
+//useCluster sets --cluster and --all-clusters for the test
+func useCluster(t *testing.T, name string, allClusters bool) {
+	oldCluster, oldAll := cluster, all
+	cluster, all = &name, &allClusters
+	t.Cleanup(func() { cluster, all = oldCluster, oldAll })
+}
+
+func newOwnedService(name, owner string) lbapi.Service {
+	svc := newTestService(name, 30080)
+	svc.Metadata.Labels = map[string]string{lbapi.LabelCluster: owner}
+	return svc
+}
+
+func TestOwnerGuard(t *testing.T) {
+	srv := useTestAPI(t)
+	useCluster(t, "nird", false)
+	srv.PutService(newOwnedService("own", "nird"))
+	srv.PutService(newOwnedService("foreign", "other"))
+	srv.PutService(newTestService("orphan", 30081))
+
+	file := filepath.Join(t.TempDir(), "services.yaml")
+	write := func(svcs ...lbapi.Service) {
+		data, _ := json.Marshal(svcs)
+		if err := ioutil.WriteFile(file, data, 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	//the foreign service blocks the whole file
+	changed := newOwnedService("own", "nird")
+	changed.Config.Ports = map[string]int32{"80": 30090}
+	write(changed, newOwnedService("foreign", "nird"))
+	if err := applyServices(file); err == nil || !strings.Contains(err.Error(), "--all-clusters") {
+		t.Errorf("applyServices() of a foreign service error = %v", err)
+	}
+	srv.AssertService(t, "own", newOwnedService("own", "nird").Config)
+	write(newOwnedService("new", "other"))
+	if err := applyServices(file); err == nil {
+		t.Errorf("applyServices() of a service of another cluster should fail")
+	}
+	srv.AssertNoService(t, "new")
+	write(changed, newTestService("orphan", 30082))
+	if err := applyServices(file); err != nil {
+		t.Errorf("applyServices() error = %v", err)
+	}
+	srv.AssertService(t, "own", changed.Config)
+
+	if err := deleteServices([]string{"orphan", "foreign"}); err == nil {
+		t.Errorf("deleteServices() of a foreign service should fail")
+	}
+	srv.AssertService(t, "orphan", newTestService("orphan", 30082).Config)
+
+	out := &bytes.Buffer{}
+	if _, err := setNodeState(out, "node1", lbapi.BackendDrain); err != nil {
+		t.Fatalf("setNodeState() error = %v", err)
+	}
+	if svc, _ := srv.Service("foreign"); svc.Config.Backends[0].State != "" {
+		t.Errorf("setNodeState() drained the foreign service")
+	}
+	for _, name := range []string{"own", "orphan"} {
+		if svc, _ := srv.Service(name); svc.Config.Backends[0].State != lbapi.BackendDrain {
+			t.Errorf("setNodeState() did not drain %s", name)
+		}
+	}
+	if !strings.Contains(out.String(), "service foreign skipped") {
+		t.Errorf("setNodeState() output = %q", out)
+	}
+
+	useCluster(t, "nird", true)
+	if err := deleteServices([]string{"foreign"}); err != nil {
+		t.Errorf("deleteServices() with --all-clusters error = %v", err)
+	}
+	srv.AssertNoService(t, "foreign")
+}
+
+func TestEndpointFailover(t *testing.T) {
+	srv := useTestAPI(t)
+	srv.PutService(newTestService("a", 30080))
+	//nothing listens on the discard port
+	useEndpoints(t, "http://127.0.0.1:9", srv.URL)
+
+	svc, found, err := apiGetService("a")
+	if err != nil || !found || svc.Metadata.Name != "a" {
+		t.Fatalf("apiGetService() = %v, %v, %v, want a from the second endpoint", svc.Metadata.Name, found, err)
+	}
+	if _, err := apiSyncService(newTestService("b", 30081)); err != nil {
+		t.Fatalf("apiSyncService() error = %v", err)
+	}
+	srv.AssertService(t, "b", newTestService("b", 30081).Config)
+}
@@ -0,0 +1,81 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"reflect"
+	"sort"
+	"strconv"
+
+	"github.com/UNINETT/lbcontroller/lbapi"
+)
+
+//diffServices returns the differences between the current and the wanted load
+//balancer service, one per changed field in the "field: current -> wanted" form.
+//The fields set by the API, the timestamps, the ingress and the connections, are ignored.
+func diffServices(current, wanted lbapi.Service) []string {
+	diffs := []string{}
+	diffValues("", comparable(current), comparable(wanted), &diffs)
+	sort.Strings(diffs)
+	return diffs
+}
+
+//comparable returns svc as generic JSON values without the fields set by the API
+func comparable(svc lbapi.Service) map[string]interface{} {
+	svc.Ingress, svc.Connections = nil, nil
+	data, _ := json.Marshal(svc)
+	ret := map[string]interface{}{}
+	json.Unmarshal(data, &ret)
+	if md, ok := ret["metadata"].(map[string]interface{}); ok {
+		delete(md, "created_at")
+		delete(md, "updated_at")
+	}
+	return ret
+}
+
+func diffValues(path string, a, b interface{}, diffs *[]string) {
+	am, aMap := a.(map[string]interface{})
+	bm, bMap := b.(map[string]interface{})
+	if aMap && bMap {
+		keys := map[string]bool{}
+		for k := range am {
+			keys[k] = true
+		}
+		for k := range bm {
+			keys[k] = true
+		}
+		for k := range keys {
+			diffValues(joinPath(path, k), am[k], bm[k], diffs)
+		}
+		return
+	}
+	al, aList := a.([]interface{})
+	bl, bList := b.([]interface{})
+	if aList && bList && len(al) == len(bl) {
+		for i := range al {
+			diffValues(path+"["+strconv.Itoa(i)+"]", al[i], bl[i], diffs)
+		}
+		return
+	}
+	if !reflect.DeepEqual(a, b) {
+		*diffs = append(*diffs, fmt.Sprintf("%s: %s -> %s", path, formatValue(a), formatValue(b)))
+	}
+}
+
+func joinPath(path, key string) string {
+	if path == "" {
+		return key
+	}
+	return path + "." + key
+}
+
+func formatValue(v interface{}) string {
+	if v == nil {
+		return "<none>"
+	}
+	data, err := json.Marshal(v)
+	if err != nil {
+		return fmt.Sprint(v)
+	}
+	return string(data)
+}
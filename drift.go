@@ -0,0 +1,180 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"log"
+	"net"
+	"net/http"
+	"sort"
+	"strings"
+
+	"github.com/UNINETT/lbcontroller/lbapi"
+	"github.com/pkg/errors"
+	"k8s.io/api/core/v1"
+)
+
+//Kinds of drift between the cluster and the load balancers
+const (
+	driftMissingInAPI     = "missing-in-api"
+	driftMissingInCluster = "missing-in-cluster"
+	driftConfig           = "config"
+	driftIngress          = "ingress"
+)
+
+//driftEntry is a difference between a Service and its load balancer service
+type driftEntry struct {
+	Kind    string   `json:"kind"`
+	Name    string   `json:"name"`              //name of the load balancer service
+	Service string   `json:"service,omitempty"` //namespace/name of the Kubernetes Service
+	Diff    []string `json:"diff,omitempty"`    //changed fields of the configuration, or the ingress
+}
+
+//readDrift compares the Services of the cluster with the load balancer services
+func readDrift(kc *kubeClient) ([]driftEntry, error) {
+	ksvcs, err := kc.listServices()
+	if err != nil {
+		return nil, errors.Wrap(err, "error reading the Kubernetes Services")
+	}
+	var svcs []lbapi.Service
+	err = lbendpoints.Do(func(url string) (err error) {
+		svcs, err = lbapi.ListServices(url, *token)
+		return err
+	})
+	if err != nil {
+		return nil, errors.Wrap(err, "error listing the load balancer services")
+	}
+	return driftReport(ksvcs, svcs), nil
+}
+
+//driftReport returns the drift between the load balancer services wanted for
+//ksvcs and svcs, the ones in the API. The Services refused by sync, which
+//keeps their load balancer services, and the services of the other clusters
+//are ignored.
+func driftReport(ksvcs []v1.Service, svcs []lbapi.Service) []driftEntry {
+	entries := []driftEntry{}
+	wanted := map[string]bool{}
+	current := map[string]lbapi.Service{}
+	for _, svc := range svcs {
+		current[svc.Metadata.Name] = svc
+	}
+
+	for _, ksvc := range ksvcs {
+		if !managedService(ksvc) {
+			continue
+		}
+		lbService, err := wantedService(ksvc, svcs)
+		id := ksvc.Namespace + "/" + ksvc.Name
+		name := lbService.Metadata.Name
+		wanted[name] = true
+		if err != nil {
+			continue
+		}
+		cur, found := current[name]
+		if !found {
+			entries = append(entries, driftEntry{Kind: driftMissingInAPI, Name: name, Service: id})
+			continue
+		}
+		if cur.CheckOwner(*cluster) != nil {
+			continue
+		}
+		lbService.KeepDrains(cur)
+		if diff := diffServices(cur, lbService); len(diff) > 0 {
+			entries = append(entries, driftEntry{Kind: driftConfig, Name: name, Service: id, Diff: diff})
+		}
+		status, api := ingressIPs(ksvc.Status.LoadBalancer.Ingress), ingressIPs(cur.Ingress)
+		if strings.Join(status, ",") != strings.Join(api, ",") {
+			entries = append(entries, driftEntry{Kind: driftIngress, Name: name, Service: id,
+				Diff: []string{fmt.Sprintf("ingress: %s -> %s", formatIPs(status), formatIPs(api))}})
+		}
+	}
+
+	for _, svc := range lbapi.ClusterServices(svcs, *cluster) {
+		if !wanted[svc.Metadata.Name] {
+			id := ""
+			if ns, n := svc.Metadata.Labels[lbapi.LabelNamespace], svc.Metadata.Labels[lbapi.LabelService]; ns != "" && n != "" {
+				id = ns + "/" + n
+			}
+			entries = append(entries, driftEntry{Kind: driftMissingInCluster, Name: svc.Metadata.Name, Service: id})
+		}
+	}
+
+	sort.SliceStable(entries, func(i, j int) bool {
+		if entries[i].Name != entries[j].Name {
+			return entries[i].Name < entries[j].Name
+		}
+		return entries[i].Kind < entries[j].Kind
+	})
+	return entries
+}
+
+//ingressIPs returns the sorted IPs of ingress
+func ingressIPs(ingress []v1.LoadBalancerIngress) []string {
+	ret := []string{}
+	for _, in := range ingress {
+		if ip := net.ParseIP(in.IP); ip != nil {
+			ret = append(ret, ip.String())
+		}
+	}
+	sort.Strings(ret)
+	return ret
+}
+
+func formatIPs(ips []string) string {
+	if len(ips) == 0 {
+		return "<none>"
+	}
+	return strings.Join(ips, ",")
+}
+
+func printDrift(w io.Writer, entries []driftEntry) {
+	counts := map[string]int{}
+	for _, e := range entries {
+		counts[e.Kind]++
+		switch e.Kind {
+		case driftMissingInAPI:
+			fmt.Fprintf(w, "+ %s (%s) is missing in the API\n", e.Name, e.Service)
+		case driftMissingInCluster:
+			fmt.Fprintf(w, "- %s (%s) is missing in the cluster\n", e.Name, e.Service)
+		default:
+			fmt.Fprintf(w, "~ %s (%s) differs, %s\n", e.Name, e.Service, e.Kind)
+			for _, d := range e.Diff {
+				fmt.Fprintf(w, "      %s\n", d)
+			}
+		}
+	}
+	fmt.Fprintf(w, "Drift: %d missing in the API, %d missing in the cluster, %d config differences, %d ingress mismatches.\n",
+		counts[driftMissingInAPI], counts[driftMissingInCluster], counts[driftConfig], counts[driftIngress])
+}
+
+//drift prints to w the drift between the cluster and the load balancers
+func drift(w io.Writer) error {
+	kc, err := newKubeClient(*kubeServer, *kubeToken)
+	if err != nil {
+		return errors.Wrap(err, "error reading the Kubernetes Services")
+	}
+	kube = kc //for the readiness probes and the nodes
+	entries, err := readDrift(kc)
+	if err != nil {
+		return err
+	}
+	printDrift(w, entries)
+	return nil
+}
+
+//driftHandler serves the drift report as JSON
+func driftHandler(w http.ResponseWriter, r *http.Request) {
+	if kube == nil {
+		http.Error(w, "the drift report needs the Kubernetes API", http.StatusServiceUnavailable)
+		return
+	}
+	entries, err := readDrift(kube)
+	if err != nil {
+		log.Printf("ERROR: %v\n", err)
+		http.Error(w, err.Error(), http.StatusBadGateway)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(entries)
+}
@@ -0,0 +1,81 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/UNINETT/lbcontroller/lbapi"
+	"k8s.io/api/core/v1"
+)
+
+func TestDriftReport(t *testing.T) {
+	port := v1.ServicePort{Protocol: v1.ProtocolTCP, Port: 80, NodePort: 30080}
+	inSync := newTestKService(v1.ServiceTypeLoadBalancer, port)
+	inSync.Status.LoadBalancer.Ingress = []v1.LoadBalancerIngress{{IP: "127.0.0.1"}}
+	missing, changed, ingress := inSync, inSync, inSync
+	missing.Name, changed.Name, ingress.Name = "missing", "changed", "ingress"
+	ingress.Status.LoadBalancer.Ingress = nil
+
+	svcs := []lbapi.Service{}
+	for _, ksvc := range []v1.Service{inSync, changed, ingress} {
+		svc := newlbcontrollerService(ksvc, serviceKey(ksvc, "tcp"), "tcp")
+		svc.Ingress = []v1.LoadBalancerIngress{{IP: "127.0.0.1"}}
+		svcs = append(svcs, svc)
+	}
+	svcs[1].Config.Method = lbapi.MethodRoundRobin
+	gone := newlbcontrollerService(newTestKService(v1.ServiceTypeLoadBalancer, port), "nirddefaultgonetcp", "tcp")
+	gone.Metadata.Labels[lbapi.LabelService] = "gone"
+	other := gone
+	other.Metadata = lbapi.Metadata{Name: "otherdefaultnginxtcp", Labels: map[string]string{lbapi.LabelCluster: "other"}}
+	svcs = append(svcs, gone, other)
+
+	got := driftReport([]v1.Service{inSync, missing, changed, ingress}, svcs)
+	want := []driftEntry{
+		{Kind: driftConfig, Name: "nirddefaultchangedtcp", Service: "default/changed", Diff: []string{`config.method: "round_robin" -> "least_conn"`}},
+		{Kind: driftMissingInCluster, Name: "nirddefaultgonetcp", Service: "default/gone"},
+		{Kind: driftIngress, Name: "nirddefaultingresstcp", Service: "default/ingress", Diff: []string{"ingress: <none> -> 127.0.0.1"}},
+		{Kind: driftMissingInAPI, Name: "nirddefaultmissingtcp", Service: "default/missing"},
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("driftReport() = %+v, want %+v", got, want)
+	}
+
+	out := &bytes.Buffer{}
+	printDrift(out, got)
+	for _, line := range []string{
+		"~ nirddefaultchangedtcp (default/changed) differs, config\n      config.method: \"round_robin\" -> \"least_conn\"\n",
+		"- nirddefaultgonetcp (default/gone) is missing in the cluster\n",
+		"+ nirddefaultmissingtcp (default/missing) is missing in the API\n",
+		"Drift: 1 missing in the API, 1 missing in the cluster, 1 config differences, 1 ingress mismatches.\n",
+	} {
+		if !strings.Contains(out.String(), line) {
+			t.Errorf("printDrift() = %q, want %q", out.String(), line)
+		}
+	}
+}
+
+func TestDriftHandler(t *testing.T) {
+	rec := httptest.NewRecorder()
+	driftHandler(rec, httptest.NewRequest(http.MethodGet, "/debug/drift", nil))
+	if rec.Code != http.StatusServiceUnavailable {
+		t.Errorf("driftHandler() without the Kubernetes API status = %d, want 503", rec.Code)
+	}
+
+	newTestAPI(t)
+	newTestServices(t, newTestKService(v1.ServiceTypeLoadBalancer,
+		v1.ServicePort{Protocol: v1.ProtocolTCP, Port: 80, NodePort: 30080}))
+	rec = httptest.NewRecorder()
+	driftHandler(rec, httptest.NewRequest(http.MethodGet, "/debug/drift", nil))
+	entries := []driftEntry{}
+	if err := json.Unmarshal(rec.Body.Bytes(), &entries); err != nil {
+		t.Fatalf("driftHandler() body %q: %v", rec.Body.String(), err)
+	}
+	if len(entries) != 1 || entries[0].Kind != driftMissingInAPI || entries[0].Service != "default/nginx" {
+		t.Errorf("driftHandler() = %+v, want nginx missing in the API", entries)
+	}
+}
@@ -0,0 +1,92 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"log"
+	"net/http"
+	gosync "sync" //the sync hook is named sync
+	"time"
+
+	"github.com/pkg/errors"
+	"k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+const eventComponent = "lbcontroller"
+
+//eventRecorder creates Kubernetes events about the Services. The metacontroller
+//calls the sync hook again and again, so an event is not repeated as long as
+//the message of its reason for the Service stays the same.
+type eventRecorder struct {
+	kube *kubeClient //nil to only log the events
+
+	mu   gosync.Mutex
+	last map[string]string //last message per Service and reason
+}
+
+//events records the events of the controller, it only logs them until serve
+//sets a Kubernetes client.
+var events = &eventRecorder{}
+
+//event records an event of type v1.EventTypeNormal or v1.EventTypeWarning
+//about ksvc. Errors are logged, events are best effort.
+func (r *eventRecorder) event(ksvc v1.Service, eventType, reason, message string) {
+	id := ksvc.Namespace + "/" + ksvc.Name
+	r.mu.Lock()
+	if r.last == nil {
+		r.last = map[string]string{}
+	}
+	repeated := r.last[id+" "+reason] == message
+	r.last[id+" "+reason] = message
+	r.mu.Unlock()
+	if repeated {
+		return
+	}
+
+	log.Printf("event %s %s on Service %s: %s\n", eventType, reason, id, message)
+	if r.kube == nil {
+		return
+	}
+	if err := r.post(ksvc, eventType, reason, message); err != nil {
+		log.Printf("ERROR: cannot record event on Service %s: %v\n", id, err)
+	}
+}
+
+//forget clears the last event of ksvc with reason, once the problem is solved,
+//so that the next one is recorded even if repeated.
+func (r *eventRecorder) forget(ksvc v1.Service, reason string) {
+	r.mu.Lock()
+	delete(r.last, ksvc.Namespace+"/"+ksvc.Name+" "+reason)
+	r.mu.Unlock()
+}
+
+func (r *eventRecorder) post(ksvc v1.Service, eventType, reason, message string) error {
+	now := metav1.NewTime(time.Now())
+	ev := v1.Event{
+		ObjectMeta: metav1.ObjectMeta{
+			GenerateName: ksvc.Name + ".",
+			Namespace:    ksvc.Namespace,
+		},
+		InvolvedObject: v1.ObjectReference{
+			APIVersion:      "v1",
+			Kind:            "Service",
+			Namespace:       ksvc.Namespace,
+			Name:            ksvc.Name,
+			UID:             ksvc.UID,
+			ResourceVersion: ksvc.ResourceVersion,
+		},
+		Reason:         reason,
+		Message:        message,
+		Type:           eventType,
+		Source:         v1.EventSource{Component: eventComponent},
+		FirstTimestamp: now,
+		LastTimestamp:  now,
+		Count:          1,
+	}
+	data, err := json.Marshal(ev)
+	if err != nil {
+		return errors.Wrap(err, "error encoding event")
+	}
+	return r.kube.do(http.MethodPost, "/api/v1/namespaces/"+ksvc.Namespace+"/events", bytes.NewReader(data), nil)
+}
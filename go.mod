@@ -1,33 +1,19 @@
 module github.com/UNINETT/lbcontroller
 
+go 1.16
+
 require (
-	github.com/alecthomas/kingpin v2.2.6+incompatible
 	github.com/alecthomas/template v0.0.0-20160405071501-a0175ee3bccc // indirect
 	github.com/alecthomas/units v0.0.0-20151022065526-2efee857e7cf // indirect
-	github.com/davecgh/go-spew v1.1.0 // indirect
-	github.com/ghodss/yaml v1.0.0 // indirect
-	github.com/gogo/protobuf v1.0.0 // indirect
+	github.com/ghodss/yaml v1.0.0
 	github.com/golang/glog v0.0.0-20160126235308-23def4e6c14b // indirect
-	github.com/google/gofuzz v0.0.0-20170612174753-24818f796faf // indirect
 	github.com/gorilla/context v1.1.1 // indirect
 	github.com/gorilla/handlers v1.3.0
 	github.com/gorilla/mux v1.6.2
-	github.com/json-iterator/go v0.0.0-20180701071628-ab8a2e0c74be // indirect
 	github.com/koki/json v0.0.0-20180412040528-e521cbda08e3
 	github.com/koki/structurederrors v0.0.0-20180506174113-6b997eb5e2ca // indirect
-	github.com/kr/pretty v0.1.0 // indirect
-	github.com/modern-go/concurrent v0.0.0-20180306012644-bacd9c7ef1dd // indirect
-	github.com/modern-go/reflect2 v0.0.0-20180718012357-94122c33edd3 // indirect
-	github.com/pkg/errors v0.8.0
-	github.com/pmezard/go-difflib v1.0.0 // indirect
-	github.com/spf13/pflag v1.0.1 // indirect
-	github.com/stretchr/testify v1.2.2 // indirect
-	github.com/urfave/cli v1.20.0
-	golang.org/x/net v0.0.0-20180629035331-4cb1c02c05b0 // indirect
-	golang.org/x/text v0.3.0 // indirect
+	github.com/pkg/errors v0.9.1
 	gopkg.in/alecthomas/kingpin.v2 v2.2.6
-	gopkg.in/inf.v0 v0.9.1 // indirect
-	gopkg.in/yaml.v2 v2.2.1 // indirect
-	k8s.io/api v0.0.0-20180629065336-ded72da34a27
-	k8s.io/apimachinery v0.0.0-20180628120107-97cca6cc910b
+	k8s.io/api v0.21.14
+	k8s.io/apimachinery v0.21.14
 )
@@ -0,0 +1,212 @@
+//Package ippool manages pools of IP addresses, IPv4 and IPv6, made of networks
+//and ranges of addresses. The pools do not keep the allocated addresses, the
+//caller passes the ones in use, so the state stays where the services are.
+package ippool
+
+import (
+	"bytes"
+	"math/big"
+	"net"
+	"strings"
+
+	"github.com/pkg/errors"
+)
+
+//Families of IP addresses
+const (
+	IPv4 = "IPv4"
+	IPv6 = "IPv6"
+)
+
+//ErrExhausted is returned when no address of the family is free in the pool
+var ErrExhausted = errors.New("no free IP address left")
+
+//Family returns the family of ip
+func Family(ip net.IP) string {
+	if ip.To4() != nil {
+		return IPv4
+	}
+	return IPv6
+}
+
+//Range is a range of IP addresses of the same family, bounds included
+type Range struct {
+	First, Last net.IP
+}
+
+//ParseRange parses a network in CIDR form, a range as 10.0.0.10-10.0.0.20, or
+//a single address. The network address of a network, and the broadcast address
+//of an IPv4 one, are excluded from its range.
+func ParseRange(s string) (Range, error) {
+	s = strings.TrimSpace(s)
+	if ip := net.ParseIP(s); ip != nil {
+		return Range{normalize(ip), normalize(ip)}, nil
+	}
+	if i := strings.Index(s, "-"); i >= 0 {
+		first, last := net.ParseIP(strings.TrimSpace(s[:i])), net.ParseIP(strings.TrimSpace(s[i+1:]))
+		if first == nil || last == nil || Family(first) != Family(last) || compare(first, last) > 0 {
+			return Range{}, errors.Errorf("invalid IP range %q", s)
+		}
+		return Range{normalize(first), normalize(last)}, nil
+	}
+	_, network, err := net.ParseCIDR(s)
+	if err != nil {
+		return Range{}, errors.Errorf("invalid IP range %q", s)
+	}
+	first := normalize(network.IP)
+	last := make(net.IP, len(first))
+	for i := range first {
+		last[i] = first[i] | ^network.Mask[i]
+	}
+	if ones, bits := network.Mask.Size(); bits-ones >= 2 {
+		first = add(first, 1)
+		if Family(first) == IPv4 {
+			last = add(last, -1)
+		}
+	}
+	return Range{first, last}, nil
+}
+
+//Family returns the family of the addresses of the range
+func (r Range) Family() string {
+	return Family(r.First)
+}
+
+//Contains tells if ip is in the range
+func (r Range) Contains(ip net.IP) bool {
+	ip = normalize(ip)
+	return len(ip) == len(r.First) && compare(ip, r.First) >= 0 && compare(ip, r.Last) <= 0
+}
+
+//Size returns the number of addresses in the range
+func (r Range) Size() *big.Int {
+	size := new(big.Int).Sub(new(big.Int).SetBytes(r.Last), new(big.Int).SetBytes(r.First))
+	return size.Add(size, big.NewInt(1))
+}
+
+func (r Range) String() string {
+	return r.First.String() + "-" + r.Last.String()
+}
+
+//Pool is a set of ranges of addresses
+type Pool struct {
+	Ranges []Range
+}
+
+//New returns the pool of the ranges, see ParseRange
+func New(ranges []string) (*Pool, error) {
+	p := &Pool{}
+	for _, s := range ranges {
+		r, err := ParseRange(s)
+		if err != nil {
+			return nil, err
+		}
+		p.Ranges = append(p.Ranges, r)
+	}
+	if len(p.Ranges) == 0 {
+		return nil, errors.New("empty IP pool")
+	}
+	return p, nil
+}
+
+//Contains tells if ip is in the pool
+func (p *Pool) Contains(ip net.IP) bool {
+	for _, r := range p.Ranges {
+		if r.Contains(ip) {
+			return true
+		}
+	}
+	return false
+}
+
+//Families returns the families of the addresses in the pool, IPv4 first
+func (p *Pool) Families() []string {
+	has := map[string]bool{}
+	for _, r := range p.Ranges {
+		has[r.Family()] = true
+	}
+	ret := []string{}
+	for _, f := range []string{IPv4, IPv6} {
+		if has[f] {
+			ret = append(ret, f)
+		}
+	}
+	return ret
+}
+
+//Allocate returns the first of the requested addresses of family in the pool and
+//not used, or else the first address of family not used.
+func (p *Pool) Allocate(family string, used map[string]bool, requested []string) (string, error) {
+	for _, s := range requested {
+		ip := net.ParseIP(s)
+		if ip != nil && Family(ip) == family && p.Contains(ip) && !used[ip.String()] {
+			return ip.String(), nil
+		}
+	}
+	for _, r := range p.Ranges {
+		if r.Family() != family {
+			continue
+		}
+		for ip := r.First; compare(ip, r.Last) <= 0; ip = add(ip, 1) {
+			if !used[ip.String()] {
+				return ip.String(), nil
+			}
+			if ip.Equal(r.Last) {
+				break
+			}
+		}
+	}
+	return "", errors.Wrapf(ErrExhausted, "family %s", family)
+}
+
+//Usage returns the number of addresses of family in the pool, and how many of
+//them are used. The size is capped to the maximum int for large IPv6 ranges.
+func (p *Pool) Usage(family string, used map[string]bool) (size, inUse int) {
+	total := new(big.Int)
+	for _, r := range p.Ranges {
+		if r.Family() == family {
+			total.Add(total, r.Size())
+		}
+	}
+	for s := range used {
+		if ip := net.ParseIP(s); ip != nil && Family(ip) == family && p.Contains(ip) {
+			inUse++
+		}
+	}
+	if !total.IsInt64() || total.Int64() > int64(^uint(0)>>1) {
+		return int(^uint(0) >> 1), inUse
+	}
+	return int(total.Int64()), inUse
+}
+
+//normalize returns IPv4 addresses in 4 bytes form
+func normalize(ip net.IP) net.IP {
+	if ip4 := ip.To4(); ip4 != nil {
+		return ip4
+	}
+	return ip.To16()
+}
+
+func compare(a, b net.IP) int {
+	return bytes.Compare(normalize(a), normalize(b))
+}
+
+//add returns ip + n, n is 1 or -1
+func add(ip net.IP, n int) net.IP {
+	ret := make(net.IP, len(ip))
+	copy(ret, ip)
+	for i := len(ret) - 1; i >= 0; i-- {
+		if n > 0 {
+			ret[i]++
+			if ret[i] != 0 {
+				break
+			}
+		} else {
+			ret[i]--
+			if ret[i] != 0xff {
+				break
+			}
+		}
+	}
+	return ret
+}
@@ -0,0 +1,73 @@
+package ippool
+
+import (
+	"testing"
+)
+
+func TestParseRange(t *testing.T) {
+	tests := []struct {
+		in, want string
+		size     int64
+	}{
+		{"10.0.0.0/24", "10.0.0.1-10.0.0.254", 254},
+		{"10.0.0.10/31", "10.0.0.10-10.0.0.11", 2},
+		{"10.0.0.10/32", "10.0.0.10-10.0.0.10", 1},
+		{"10.0.0.10 - 10.0.0.20", "10.0.0.10-10.0.0.20", 11},
+		{"10.0.0.10", "10.0.0.10-10.0.0.10", 1},
+		{"2001:db8::/126", "2001:db8::1-2001:db8::3", 3},
+		{"2001:db8::10-2001:db8::1f", "2001:db8::10-2001:db8::1f", 16},
+	}
+	for _, tt := range tests {
+		r, err := ParseRange(tt.in)
+		if err != nil {
+			t.Errorf("ParseRange(%q) error = %v", tt.in, err)
+			continue
+		}
+		if r.String() != tt.want || r.Size().Int64() != tt.size {
+			t.Errorf("ParseRange(%q) = %s of %d, want %s of %d", tt.in, r, r.Size(), tt.want, tt.size)
+		}
+	}
+
+	for _, invalid := range []string{"10.0.0.0/33", "10.0.0.20-10.0.0.10", "10.0.0.1-2001:db8::1", "foo"} {
+		if _, err := ParseRange(invalid); err == nil {
+			t.Errorf("ParseRange(%q) should fail", invalid)
+		}
+	}
+}
+
+func TestAllocate(t *testing.T) {
+	p, err := New([]string{"10.0.0.1-10.0.0.2", "10.0.1.0/30", "2001:db8::/64"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := p.Families(); len(got) != 2 || got[0] != IPv4 || got[1] != IPv6 {
+		t.Errorf("Families() = %v", got)
+	}
+
+	used := map[string]bool{}
+	want := []string{"10.0.0.1", "10.0.0.2", "10.0.1.1", "10.0.1.2"}
+	for _, w := range want {
+		ip, err := p.Allocate(IPv4, used, nil)
+		if err != nil || ip != w {
+			t.Fatalf("Allocate() = %s, %v, want %s", ip, err, w)
+		}
+		used[ip] = true
+	}
+	if _, err := p.Allocate(IPv4, used, nil); err == nil {
+		t.Errorf("Allocate() of an exhausted pool should fail")
+	}
+	if size, inUse := p.Usage(IPv4, used); size != 4 || inUse != 4 {
+		t.Errorf("Usage() = %d, %d, want 4, 4", size, inUse)
+	}
+
+	ip, err := p.Allocate(IPv6, used, []string{"10.0.0.5", "2001:db8::10"})
+	if err != nil || ip != "2001:db8::10" {
+		t.Errorf("Allocate() of a requested IP = %s, %v", ip, err)
+	}
+	if ip, err := p.Allocate(IPv6, used, []string{"2001:db9::10"}); err != nil || ip != "2001:db8::1" {
+		t.Errorf("Allocate() of a requested IP outside the pool = %s, %v, want the first free", ip, err)
+	}
+	if size, _ := p.Usage(IPv6, used); size != int(^uint(0)>>1) {
+		t.Errorf("Usage() of a /64 = %d, want the maximum int", size)
+	}
+}
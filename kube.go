@@ -0,0 +1,137 @@
+package main
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/json"
+	"io"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"net/url"
+	"os"
+	"sort"
+	"strings"
+
+	"github.com/pkg/errors"
+	"k8s.io/api/core/v1"
+)
+
+const serviceAccountDir = "/var/run/secrets/kubernetes.io/serviceaccount"
+
+//kubeClient is a minimal client of the Kubernetes API,
+//enough for the few objects the controller reads and writes.
+type kubeClient struct {
+	server string
+	token  string
+	client *http.Client
+}
+
+//newKubeClient returns a client of the Kubernetes API at server, e.g. the
+//address of kubectl proxy. If server is empty the in-cluster configuration
+//of the service account of the pod is used.
+func newKubeClient(server, token string) (*kubeClient, error) {
+	if server != "" {
+		return &kubeClient{
+			server: strings.TrimSuffix(server, "/"),
+			token:  token,
+			client: http.DefaultClient,
+		}, nil
+	}
+
+	host, port := os.Getenv("KUBERNETES_SERVICE_HOST"), os.Getenv("KUBERNETES_SERVICE_PORT")
+	if host == "" || port == "" {
+		return nil, errors.New("not running in a cluster, specify the Kubernetes API server")
+	}
+	saToken, err := ioutil.ReadFile(serviceAccountDir + "/token")
+	if err != nil {
+		return nil, errors.Wrap(err, "error reading the service account token")
+	}
+	ca, err := ioutil.ReadFile(serviceAccountDir + "/ca.crt")
+	if err != nil {
+		return nil, errors.Wrap(err, "error reading the service account CA")
+	}
+	pool := x509.NewCertPool()
+	if !pool.AppendCertsFromPEM(ca) {
+		return nil, errors.New("invalid service account CA")
+	}
+	if token == "" {
+		token = strings.TrimSpace(string(saToken))
+	}
+	return &kubeClient{
+		server: "https://" + net.JoinHostPort(host, port),
+		token:  token,
+		client: &http.Client{
+			Transport: &http.Transport{TLSClientConfig: &tls.Config{RootCAs: pool}},
+		},
+	}, nil
+}
+
+//do sends a request to the API and decodes the response in out, if not nil
+func (k *kubeClient) do(method, path string, body io.Reader, out interface{}) error {
+	req, err := http.NewRequest(method, k.server+path, body)
+	if err != nil {
+		return errors.Wrap(err, "error creating http request")
+	}
+	req.Header.Set("Accept", "application/json")
+	if body != nil {
+		req.Header.Set("Content-Type", "application/json")
+	}
+	if k.token != "" {
+		req.Header.Set("Authorization", "Bearer "+k.token)
+	}
+	res, err := k.client.Do(req)
+	if err != nil {
+		return errors.Wrapf(err, "error connecting to the Kubernetes API: %s", k.server)
+	}
+	defer res.Body.Close()
+
+	data, err := ioutil.ReadAll(res.Body)
+	if err != nil {
+		return errors.Wrapf(err, "error reading from the Kubernetes API: %s", k.server)
+	}
+	if res.StatusCode < 200 || res.StatusCode > 299 {
+		return errors.Errorf("Kubernetes API returned status %s for %s %s: %s", res.Status, method, path, strings.TrimSpace(string(data)))
+	}
+	if out == nil {
+		return nil
+	}
+	return errors.Wrapf(json.Unmarshal(data, out), "error decoding the response of %s", path)
+}
+
+//listServices returns the Services of all the namespaces
+func (k *kubeClient) listServices() ([]v1.Service, error) {
+	list := v1.ServiceList{}
+	if err := k.do(http.MethodGet, "/api/v1/services", nil, &list); err != nil {
+		return nil, err
+	}
+	return list.Items, nil
+}
+
+//listPods returns the pods of namespace matching selector
+func (k *kubeClient) listPods(namespace string, selector map[string]string) ([]v1.Pod, error) {
+	keys := make([]string, 0, len(selector))
+	for key := range selector {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	terms := make([]string, 0, len(keys))
+	for _, key := range keys {
+		terms = append(terms, key+"="+selector[key])
+	}
+	list := v1.PodList{}
+	path := "/api/v1/namespaces/" + namespace + "/pods?labelSelector=" + url.QueryEscape(strings.Join(terms, ","))
+	if err := k.do(http.MethodGet, path, nil, &list); err != nil {
+		return nil, err
+	}
+	return list.Items, nil
+}
+
+//listNodes returns the nodes of the cluster
+func (k *kubeClient) listNodes() ([]v1.Node, error) {
+	list := v1.NodeList{}
+	if err := k.do(http.MethodGet, "/api/v1/nodes", nil, &list); err != nil {
+		return nil, err
+	}
+	return list.Items, nil
+}
@@ -0,0 +1,146 @@
+package lbapi_test
+
+import (
+	"net/http"
+	"testing"
+
+	"github.com/UNINETT/lbcontroller/lbapi"
+	"github.com/UNINETT/lbcontroller/test/lbcontrollertest"
+)
+
+const testToken = "testtoken"
+
+func newTestService(name string) lbapi.Service {
+	return lbapi.Service{
+		Type:     lbapi.TCP,
+		Metadata: lbapi.Metadata{Name: name},
+		Config: lbapi.Config{
+			Method: "least_conn",
+			Ports:  map[string]int32{"80": 30080},
+			Backends: []lbapi.Backend{
+				{Host: "hostname1.example.com", Addrs: []string{"10.3.2.43", "2001:700:f00d::8"}},
+			},
+			UpstreamMaxConns: 100,
+		},
+	}
+}
+
+func TestSyncService(t *testing.T) {
+	srv := lbcontrollertest.NewServer(lbcontrollertest.Options{Token: testToken})
+	defer srv.Close()
+
+	svc := newTestService("testservice")
+	ingress, err := lbapi.SyncService(svc, srv.URL, testToken)
+	if err != nil {
+		t.Fatalf("SyncService() create error = %v", err)
+	}
+	if len(ingress) != 1 || ingress[0].IP != "127.0.0.1" {
+		t.Errorf("SyncService() ingress = %v, want 127.0.0.1", ingress)
+	}
+	srv.AssertService(t, "testservice", svc.Config)
+
+	svc.Config.Ports["443"] = 30443
+	updated, err := lbapi.SyncService(svc, srv.URL, testToken)
+	if err != nil {
+		t.Fatalf("SyncService() update error = %v", err)
+	}
+	if len(updated) != 1 || updated[0].IP != ingress[0].IP {
+		t.Errorf("SyncService() update changed ingress to %v, want %v", updated, ingress)
+	}
+	srv.AssertService(t, "testservice", svc.Config)
+
+	var status []int
+	for _, r := range srv.Requests() {
+		if r.Method == http.MethodPut {
+			status = append(status, r.Status)
+		}
+	}
+	if len(status) != 2 || status[0] != http.StatusCreated || status[1] != http.StatusOK {
+		t.Errorf("SyncService() PUT status = %v, want a create and an update", status)
+	}
+
+	svc.Config.Backends = nil
+	if _, err := lbapi.SyncService(svc, srv.URL, testToken); err == nil {
+		t.Errorf("SyncService() of an invalid service should fail")
+	}
+	if _, err := lbapi.SyncService(newTestService("other"), srv.URL, "wrong"); err == nil {
+		t.Errorf("SyncService() with a wrong token should fail")
+	}
+	srv.AssertNoService(t, "other")
+}
+
+func TestGetListDeleteService(t *testing.T) {
+	srv := lbcontrollertest.NewServer(lbcontrollertest.Options{Token: testToken})
+	defer srv.Close()
+
+	for _, name := range []string{"a", "b"} {
+		if err := srv.PutService(newTestService(name)); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	svc, found, err := lbapi.GetService("b", srv.URL, testToken)
+	if err != nil || !found {
+		t.Fatalf("GetService() = %v, %v, want found", found, err)
+	}
+	if svc.Metadata.Name != "b" || len(svc.Ingress) != 1 {
+		t.Errorf("GetService() = %+v, want service b with ingress", svc)
+	}
+	if _, found, err := lbapi.GetService("c", srv.URL, testToken); err != nil || found {
+		t.Errorf("GetService() of a missing service = %v, %v, want not found", found, err)
+	}
+
+	svcs, err := lbapi.ListServices(srv.URL, testToken)
+	if err != nil || len(svcs) != 2 {
+		t.Fatalf("ListServices() = %v, %v, want 2 services", svcs, err)
+	}
+
+	if err := lbapi.DeleteService("a", srv.URL, testToken); err != nil {
+		t.Fatalf("DeleteService() error = %v", err)
+	}
+	srv.AssertNoService(t, "a")
+}
+
+func TestServerErrors(t *testing.T) {
+	srv := lbcontrollertest.NewServer(lbcontrollertest.Options{})
+	defer srv.Close()
+
+	if err := srv.AddFault(lbcontrollertest.Fault{Path: "/services", ErrorRate: 1}); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := lbapi.SyncService(newTestService("a"), srv.URL, testToken); err == nil {
+		t.Errorf("SyncService() should fail on 5xx")
+	}
+	if _, err := lbapi.ListServices(srv.URL, testToken); err == nil {
+		t.Errorf("ListServices() should fail on 5xx")
+	}
+	srv.ClearFaults()
+	if _, err := lbapi.SyncService(newTestService("a"), srv.URL, testToken); err != nil {
+		t.Errorf("SyncService() error = %v after clearing the faults", err)
+	}
+}
+
+func TestFrontends(t *testing.T) {
+	srv := lbcontrollertest.NewServer(lbcontrollertest.Options{Token: testToken})
+	defer srv.Close()
+
+	f := lbapi.Frontend{
+		Metadata: lbapi.Metadata{Name: "foobar"},
+		Config:   map[string]interface{}{"addrs": []interface{}{"10.0.0.1"}},
+	}
+	if err := srv.PutFrontend(f); err != nil {
+		t.Fatal(err)
+	}
+
+	fronts, err := lbapi.ListFrontends(srv.URL, testToken)
+	if err != nil || len(fronts) != 1 || fronts[0].Metadata.Name != "foobar" {
+		t.Errorf("ListFrontends() = %v, %v, want foobar", fronts, err)
+	}
+	got, found, err := lbapi.GetFrontend("foobar", srv.URL, testToken)
+	if err != nil || !found || got.Config["addrs"] == nil {
+		t.Errorf("GetFrontend() = %+v, %v, %v, want foobar", got, found, err)
+	}
+	if _, found, err := lbapi.GetFrontend("missing", srv.URL, testToken); err != nil || found {
+		t.Errorf("GetFrontend() of a missing frontend = %v, %v, want not found", found, err)
+	}
+}
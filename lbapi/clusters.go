@@ -0,0 +1,53 @@
+package lbapi
+
+import (
+	"github.com/pkg/errors"
+)
+
+//Labels set by the controller on the services it creates
+const (
+	//LabelCluster is the name of the Kubernetes cluster owning the service
+	LabelCluster = "lbcontroller.uninett.no/cluster"
+	//LabelNamespace is the namespace of the Kubernetes Service
+	LabelNamespace = "lbcontroller.uninett.no/namespace"
+	//LabelService is the name of the Kubernetes Service
+	LabelService = "lbcontroller.uninett.no/service"
+	//LabelClass is the loadBalancerClass of the Kubernetes Service, if any
+	LabelClass = "lbcontroller.uninett.no/class"
+)
+
+//Cluster returns the name of the cluster owning the service, empty if the
+//service was not created by a controller, or by a version without labels.
+func (s Service) Cluster() string {
+	return s.Metadata.Labels[LabelCluster]
+}
+
+//CheckOwner returns an error if the service is owned by a cluster other than cluster.
+//Services without owner are accepted, so a controller can adopt them.
+func (s Service) CheckOwner(cluster string) error {
+	if owner := s.Cluster(); owner != "" && owner != cluster {
+		return errors.Errorf("service %s is owned by cluster %s, not %s", s.Metadata.Name, owner, cluster)
+	}
+	return nil
+}
+
+//ClusterServices returns the services in svcs owned by cluster
+func ClusterServices(svcs []Service, cluster string) []Service {
+	ret := []Service{}
+	for _, svc := range svcs {
+		if svc.Cluster() == cluster {
+			ret = append(ret, svc)
+		}
+	}
+	return ret
+}
+
+//Clusters returns the number of services of each cluster in svcs,
+//the services without owner are counted under the empty name.
+func Clusters(svcs []Service) map[string]int {
+	ret := map[string]int{}
+	for _, svc := range svcs {
+		ret[svc.Cluster()]++
+	}
+	return ret
+}
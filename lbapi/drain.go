@@ -0,0 +1,81 @@
+package lbapi
+
+import (
+	"sort"
+	"strings"
+)
+
+//LabelDrained lists the hosts of the backends drained by an operator, comma
+//separated. The controller keeps their state, the other states are its own.
+const LabelDrained = "lbcontroller.uninett.no/drained"
+
+//MatchHost tells if the backend host is node, by name or short name
+func MatchHost(host, node string) bool {
+	return host == node || strings.SplitN(host, ".", 2)[0] == node
+}
+
+//DrainedHosts returns the hosts drained by an operator, see LabelDrained
+func (s Service) DrainedHosts() []string {
+	v := s.Metadata.Labels[LabelDrained]
+	if v == "" {
+		return nil
+	}
+	return strings.Split(v, ",")
+}
+
+//SetBackendState sets the state of the backends on node, recording them in
+//LabelDrained unless state is up (empty). It returns false if no backend is on node.
+func (s *Service) SetBackendState(node, state string) bool {
+	found := false
+	for i, b := range s.Config.Backends {
+		if !MatchHost(b.Host, node) {
+			continue
+		}
+		found = true
+		s.Config.Backends[i].State = state
+		hosts := []string{}
+		for _, h := range s.DrainedHosts() {
+			if h != b.Host {
+				hosts = append(hosts, h)
+			}
+		}
+		if state != "" {
+			hosts = append(hosts, b.Host)
+		}
+		s.setDrainedHosts(hosts)
+	}
+	return found
+}
+
+//KeepDrains copies to s the states of the backends drained by an operator in
+//current, the service in the API.
+func (s *Service) KeepDrains(current Service) {
+	drained := current.DrainedHosts()
+	if len(drained) == 0 {
+		return
+	}
+	states := map[string]string{}
+	for _, b := range current.Config.Backends {
+		states[b.Host] = b.State
+	}
+	kept := []string{}
+	for i, b := range s.Config.Backends {
+		if contains(drained, b.Host) {
+			s.Config.Backends[i].State = states[b.Host]
+			kept = append(kept, b.Host)
+		}
+	}
+	s.setDrainedHosts(kept)
+}
+
+func (s *Service) setDrainedHosts(hosts []string) {
+	if len(hosts) == 0 {
+		delete(s.Metadata.Labels, LabelDrained)
+		return
+	}
+	if s.Metadata.Labels == nil {
+		s.Metadata.Labels = map[string]string{}
+	}
+	sort.Strings(hosts)
+	s.Metadata.Labels[LabelDrained] = strings.Join(hosts, ",")
+}
@@ -0,0 +1,161 @@
+package lbapi
+
+import (
+	"expvar"
+	"net"
+	"net/http"
+	"net/url"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/pkg/errors"
+)
+
+//Selection modes of the endpoints
+const (
+	//Failover sends all the calls to the first healthy endpoint, in the given order
+	Failover = "failover"
+	//RoundRobin spreads the calls over the healthy endpoints
+	RoundRobin = "round-robin"
+)
+
+//endpointStats are the calls served by each endpoint, errors are the failed
+//tries and failovers the calls taken over from a failed endpoint, published
+//by expvar as lbapi_endpoints, e.g. {"https://lb1.example.com": {"calls": 10, "errors": 1, "failovers": 1}}
+var (
+	endpointStats   = expvar.NewMap("lbapi_endpoints")
+	endpointStatsMu sync.Mutex
+)
+
+func addEndpointStat(endpoint, key string) {
+	endpointStatsMu.Lock()
+	defer endpointStatsMu.Unlock()
+	m, ok := endpointStats.Get(endpoint).(*expvar.Map)
+	if !ok {
+		m = new(expvar.Map).Init()
+		endpointStats.Set(endpoint, m)
+	}
+	m.Add(key, 1)
+}
+
+//Endpoints is a set of equivalent API endpoints, e.g. in different sites.
+//An endpoint failing with a failover error is marked down for the backoff
+//period and the call is retried on the next one.
+type Endpoints struct {
+	urls    []string
+	mode    string
+	backoff time.Duration
+
+	mu        sync.Mutex
+	next      int
+	downUntil map[string]time.Time
+}
+
+//NewEndpoints returns the endpoints in urls, selected according to mode
+func NewEndpoints(urls []string, mode string, backoff time.Duration) (*Endpoints, error) {
+	e := &Endpoints{mode: mode, backoff: backoff, downUntil: map[string]time.Time{}}
+	for _, u := range urls {
+		u = strings.TrimSuffix(strings.TrimSpace(u), "/")
+		if u == "" {
+			continue
+		}
+		if _, err := url.ParseRequestURI(u); err != nil {
+			return nil, errors.Wrapf(err, "invalid endpoint %s", u)
+		}
+		e.urls = append(e.urls, u)
+	}
+	if len(e.urls) == 0 {
+		return nil, errors.New("no endpoint specified")
+	}
+	if mode != Failover && mode != RoundRobin {
+		return nil, errors.Errorf("unknown endpoint selection mode %s", mode)
+	}
+	return e, nil
+}
+
+//URLs returns the endpoints
+func (e *Endpoints) URLs() []string {
+	return append([]string{}, e.urls...)
+}
+
+//order returns the endpoints in the order they should be tried, the healthy ones first
+func (e *Endpoints) order() []string {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+
+	start := 0
+	if e.mode == RoundRobin {
+		start = e.next
+		e.next = (e.next + 1) % len(e.urls)
+	}
+	now := time.Now()
+	healthy, down := []string{}, []string{}
+	for i := range e.urls {
+		u := e.urls[(start+i)%len(e.urls)]
+		if now.Before(e.downUntil[u]) {
+			down = append(down, u)
+		} else {
+			healthy = append(healthy, u)
+		}
+	}
+	return append(healthy, down...)
+}
+
+func (e *Endpoints) setDown(u string, down bool) {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	if down {
+		e.downUntil[u] = time.Now().Add(e.backoff)
+	} else {
+		delete(e.downUntil, u)
+	}
+}
+
+//Do calls call with the url of an endpoint, and with the next ones as long as
+//call fails with a connection error or an unavailable API, see IsFailoverError.
+//Other errors, e.g. an invalid service, are returned at once as the other
+//endpoints would fail the same way.
+func (e *Endpoints) Do(call func(url string) error) error {
+	var err error
+	for i, u := range e.order() {
+		err = call(u)
+		if !IsFailoverError(err) {
+			addEndpointStat(u, "calls")
+			if i > 0 {
+				addEndpointStat(u, "failovers")
+			}
+			e.setDown(u, false)
+			return err
+		}
+		addEndpointStat(u, "errors")
+		e.setDown(u, true)
+	}
+	return errors.Wrap(err, "all the endpoints failed")
+}
+
+//IsFailoverError tells if a call failing with err should be tried on another
+//endpoint: the API cannot be reached, or it answers with a 5xx status, e.g. a
+//proxy in front of a site which is down, or 429 Too Many Requests.
+func IsFailoverError(err error) bool {
+	if IsConnectionError(err) {
+		return true
+	}
+	if se, ok := errors.Cause(err).(*StatusError); ok {
+		return se.StatusCode >= 500 || se.StatusCode == http.StatusTooManyRequests
+	}
+	return false
+}
+
+//IsConnectionError tells if err is caused by a failure to reach the API,
+//rather than by an error returned by the API.
+func IsConnectionError(err error) bool {
+	if err == nil {
+		return false
+	}
+	switch errors.Cause(err).(type) {
+	case *url.Error, net.Error:
+		return true
+	}
+	return false
+}
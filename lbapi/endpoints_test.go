@@ -0,0 +1,146 @@
+package lbapi_test
+
+import (
+	"expvar"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/UNINETT/lbcontroller/lbapi"
+	"github.com/UNINETT/lbcontroller/test/lbcontrollertest"
+)
+
+//deadEndpoint returns the URL of a server which is not running anymore
+func deadEndpoint() string {
+	srv := httptest.NewServer(nil)
+	srv.Close()
+	return srv.URL
+}
+
+func endpointStat(url, key string) string {
+	m, ok := expvar.Get("lbapi_endpoints").(*expvar.Map)
+	if !ok {
+		return ""
+	}
+	stats, ok := m.Get(url).(*expvar.Map)
+	if !ok || stats.Get(key) == nil {
+		return "0"
+	}
+	return stats.Get(key).String()
+}
+
+func TestEndpointsFailover(t *testing.T) {
+	srv := lbcontrollertest.NewServer(lbcontrollertest.Options{Token: testToken})
+	defer srv.Close()
+	dead := deadEndpoint()
+
+	e, err := lbapi.NewEndpoints([]string{dead, srv.URL}, lbapi.Failover, time.Minute)
+	if err != nil {
+		t.Fatal(err)
+	}
+	svc := newTestService("testservice")
+	for i := 0; i < 3; i++ {
+		var used string
+		err := e.Do(func(url string) error {
+			used = url
+			_, err := lbapi.SyncService(svc, url, testToken)
+			return err
+		})
+		if err != nil {
+			t.Fatalf("Do() error = %v", err)
+		}
+		if used != srv.URL {
+			t.Errorf("Do() served by %s, want %s", used, srv.URL)
+		}
+	}
+	srv.AssertService(t, "testservice", svc.Config)
+
+	//the dead endpoint is tried once, then avoided during the backoff
+	if got := endpointStat(dead, "errors"); got != "1" {
+		t.Errorf("errors of the dead endpoint = %s, want 1", got)
+	}
+	if got := endpointStat(dead, "calls"); got != "0" {
+		t.Errorf("calls of the dead endpoint = %s, want 0", got)
+	}
+	if got := endpointStat(srv.URL, "calls"); got != "3" {
+		t.Errorf("calls of the live endpoint = %s, want 3", got)
+	}
+	if got := endpointStat(srv.URL, "failovers"); got != "1" {
+		t.Errorf("failovers to the live endpoint = %s, want 1", got)
+	}
+
+	//API errors are not retried on the other endpoints
+	calls := 0
+	e.Do(func(url string) error {
+		calls++
+		_, err := lbapi.SyncService(lbapi.Service{Metadata: lbapi.Metadata{Name: "invalid"}}, url, testToken)
+		return err
+	})
+	if calls != 1 {
+		t.Errorf("Do() tried %d endpoints for an invalid service, want 1", calls)
+	}
+}
+
+func TestEndpointsFailoverStatus(t *testing.T) {
+	for _, fault := range []lbcontrollertest.Fault{
+		{ErrorRate: 1, Status: 503},
+		{ErrorRate: 1, Status: 502},
+		{ThrottleRate: 1},
+	} {
+		down := lbcontrollertest.NewServer(lbcontrollertest.Options{Token: testToken})
+		defer down.Close()
+		down.AddFault(fault)
+		srv := lbcontrollertest.NewServer(lbcontrollertest.Options{Token: testToken})
+		defer srv.Close()
+
+		e, err := lbapi.NewEndpoints([]string{down.URL, srv.URL}, lbapi.Failover, time.Minute)
+		if err != nil {
+			t.Fatal(err)
+		}
+		svc := newTestService("testservice")
+		err = e.Do(func(url string) error {
+			_, err := lbapi.SyncService(svc, url, testToken)
+			return err
+		})
+		if err != nil {
+			t.Fatalf("Do() with %+v error = %v", fault, err)
+		}
+		srv.AssertService(t, "testservice", svc.Config)
+		if got := endpointStat(down.URL, "errors"); got != "1" {
+			t.Errorf("errors of the endpoint with %+v = %s, want 1", fault, got)
+		}
+		if got := endpointStat(srv.URL, "failovers"); got != "1" {
+			t.Errorf("failovers from the endpoint with %+v = %s, want 1", fault, got)
+		}
+	}
+}
+
+func TestEndpointsRoundRobin(t *testing.T) {
+	srv1 := lbcontrollertest.NewServer(lbcontrollertest.Options{Token: testToken})
+	defer srv1.Close()
+	srv2 := lbcontrollertest.NewServer(lbcontrollertest.Options{Token: testToken})
+	defer srv2.Close()
+
+	e, err := lbapi.NewEndpoints([]string{srv1.URL, srv2.URL + "/"}, lbapi.RoundRobin, time.Minute)
+	if err != nil {
+		t.Fatal(err)
+	}
+	used := map[string]int{}
+	for i := 0; i < 4; i++ {
+		e.Do(func(url string) error {
+			used[url]++
+			_, err := lbapi.ListServices(url, testToken)
+			return err
+		})
+	}
+	if used[srv1.URL] != 2 || used[srv2.URL] != 2 {
+		t.Errorf("Do() used %v, want the endpoints 2 times each", used)
+	}
+
+	if _, err := lbapi.NewEndpoints(nil, lbapi.Failover, time.Minute); err == nil {
+		t.Errorf("NewEndpoints() without endpoints should fail")
+	}
+	if _, err := lbapi.NewEndpoints([]string{"localhost"}, lbapi.Failover, time.Minute); err == nil {
+		t.Errorf("NewEndpoints() with an invalid URL should fail")
+	}
+}
@@ -0,0 +1,120 @@
+package lbapi
+
+import (
+	"bytes"
+	"io/ioutil"
+	"net/http"
+
+	"github.com/koki/json"
+	"github.com/pkg/errors"
+)
+
+//Frontend handled by the load balancers, services use it by name in their configuration.
+//The configuration of the frontend is not interpreted by the client.
+type Frontend struct {
+	Metadata Metadata               `json:"metadata,omitempty"`
+	Config   map[string]interface{} `json:"config,omitempty"`
+}
+
+//ListFrontends return a list of frontends
+//configured on the loadbalancers.
+//A token is needed to authenticate
+func ListFrontends(url, token string) ([]Frontend, error) {
+	url = frontendURL(url)
+
+	req, err := newRequest(http.MethodGet, url, token, nil)
+	if err != nil {
+		return nil, errors.Wrap(err, "error creatign http.Request")
+	}
+	res, err := http.DefaultClient.Do(req)
+	if err != nil {
+		return nil, errors.Wrapf(err, "error connecting to API endpoint: %s", url)
+	}
+	defer res.Body.Close()
+
+	if res.StatusCode != http.StatusOK {
+		return nil, statusError(res, "error, returned status not 200 OK from API endpoint: %s", res.Status)
+	}
+
+	dec := json.NewDecoder(res.Body)
+	fronts := []Frontend{}
+	for dec.More() {
+		var f Frontend
+		if err := dec.Decode(&f); err != nil {
+			return nil, errors.Wrap(err, "error decoding a Frontend object")
+		}
+		fronts = append(fronts, f)
+	}
+
+	return fronts, nil
+}
+
+//GetFrontend get the frontend specified by name, if the frontend
+//is found GetFrontend returnns a true boolean value as well
+//A token is needed to authenticate
+func GetFrontend(name, url, token string) (Frontend, bool, error) {
+	url = frontendURL(url)
+	ret := Frontend{}
+
+	req, err := newRequest(http.MethodGet, url+"/"+name, token, nil)
+	if err != nil {
+		return ret, false, errors.Wrapf(err, "error creating http request")
+	}
+	res, err := http.DefaultClient.Do(req)
+	if err != nil {
+		return ret, false, errors.Wrapf(err, "error connecting to API endpoint: %s", url)
+	}
+	defer res.Body.Close()
+
+	body, err := ioutil.ReadAll(res.Body)
+	if err != nil {
+		return ret, false, errors.Wrapf(err, "error reading from API endpoint: %s", url)
+	}
+
+	switch res.StatusCode {
+	case http.StatusNotFound:
+		return ret, false, nil
+	case http.StatusOK:
+	default:
+		return ret, false, statusError(res, "error, returned status from API endpoint not supported: %s\n ", res.Status)
+	}
+
+	if err := json.Unmarshal(body, &ret); err != nil {
+		return ret, false, errors.Wrap(err, "error decoding Frontend object")
+	}
+	return ret, true, nil
+}
+
+func frontendURL(url string) string {
+	return url + "/" + frontendPath
+}
+
+//SyncFrontend creates or updates a frontend
+//A token is needed to authenticate
+func SyncFrontend(f Frontend, url, token string) error {
+	url = frontendURL(url)
+	data, err := json.Marshal(f)
+	if err != nil {
+		return errors.Wrap(err, "error marshalling Frontend")
+	}
+
+	req, err := newRequest(http.MethodPut, url+"/"+f.Metadata.Name, token, bytes.NewBuffer(data))
+	if err != nil {
+		return errors.Wrap(err, "error creating http request")
+	}
+	res, err := http.DefaultClient.Do(req)
+	if err != nil {
+		return errors.Wrapf(err, "error sync-ing Frontend %s", f.Metadata.Name)
+	}
+	defer res.Body.Close()
+
+	switch res.StatusCode {
+	case http.StatusCreated, http.StatusOK:
+		return nil
+	}
+	body, err := ioutil.ReadAll(res.Body)
+	if err != nil {
+		return errors.Wrapf(err, "error reading from API endpoint: %s", url)
+	}
+	return statusError(res, "API endpoint returned status %s, %s", res.Status, bytes.TrimSpace(body))
+}
@@ -0,0 +1,185 @@
+//Package lbapi is a client of the Uninett load balancer API,
+//used by the controller and by the mock of the API in test/lbcontrollertest.
+package lbapi
+
+import (
+	"bytes"
+	"fmt"
+	"net/http"
+	"time"
+
+	"github.com/koki/json"
+	"github.com/pkg/errors"
+)
+
+const (
+	jsonContent = "application/json"
+)
+
+var (
+	servicePath  = "services"
+	frontendPath = "frontends"
+	poolPath     = "pools"
+)
+
+//Metadata of messages sent to the API
+//"metadata": {
+//	"name": "testservice",
+//	"created_at": "......",
+//	"updated_at": "......",
+//	...
+//}
+type Metadata struct {
+	Name      string    `json:"name,omitempty"`
+	CreatedAt time.Time `json:"created_at,omitempty"`
+	UpdatedAt time.Time `json:"updated_at,omitempty"`
+	//Labels identify the owner of the object, see LabelCluster
+	Labels map[string]string `json:"labels,omitempty"`
+}
+
+// Config represent the configuration of a TCP load balanced service, e.g.:
+// "config": {
+// 	"method": "least_conn",
+// 	"affinity_timeout": 10800, //source_hash method only
+// 	"ports": [80, 443],
+// 	"backends": [
+// 		 "hostname1.example.com": {
+// 			 "addrs": ["10.3.2.43", "2001:700:f00d::8"]
+// 		 },
+// 		 "hostname2.example.com": {
+// 			 "addrs": ["10.3.2.53", "2001:700:f00d::18"],
+// 			 "weight": 2,
+// 			 "max_conns": 200,
+// 			 "state": "drain"
+// 		 }
+// 	],
+// 	"upstream_max_conns": 100,
+// 	"acl": ["10.10.20.0/24", "2001:700:1337::/48"],
+// 	"health_check": {
+// 		 "port": 1337,
+// 		 "send": "healthz\n",
+// 		 "expect": "^OK$"
+// },
+// or an HTTP health check:
+// 	"health_check": {
+// 		 "type": "http",
+// 		 "port": 32000,
+// 		 "path": "/healthz",
+// 		 "expect_status": 200,
+// 		 "interval": 5,
+// 		 "timeout": 2,
+// 		 "healthy_threshold": 2,
+// 		 "unhealthy_threshold": 3
+// },
+// 	"frontend": "foobar",
+// 	"requested_ips": ["158.39.77.10", "2001:700:2::10"],
+// 	"ip_families": ["IPv4", "IPv6"]
+// }
+type Config struct {
+	Method           string           `json:"method,omitempty"`
+	AffinityTimeout  int              `json:"affinity_timeout,omitempty"` //seconds a client sticks to its backend, source_hash only
+	Ports            map[string]int32 `json:"ports,omitempty"`
+	Backends         []Backend        `json:"backends,omitempty"`
+	UpstreamMaxConns int              `json:"upstream_max_conns,omitempty"`
+	ACL              []string         `json:"acl,omitempty"`
+	HealthCheck      HealthCheck      `json:"health_check,omitempty"`
+	Frontend         string           `json:"frontend,omitempty"`
+	RequestedIPs     []string         `json:"requested_ips,omitempty"` //ingress IPs asked for, at most one per family
+	IPFamilies       []string         `json:"ip_families,omitempty"`   //families of the ingress IPs, IPv4 and IPv6, one IP per family
+}
+
+// Backend represents a backend in the loadbalancer configuration
+type Backend struct {
+	Host  string   `json:"host,omitempty"`
+	Addrs []string `json:"addrs,omitempty"`
+	//Weight of the backend relative to the others, 1 if 0
+	Weight int `json:"weight,omitempty"`
+	//MaxConns overrides UpstreamMaxConns for the backend
+	MaxConns int `json:"max_conns,omitempty"`
+	//State is up if empty, see BackendDrain and BackendDown
+	State string `json:"state,omitempty"`
+}
+
+//States of the backends
+const (
+	//BackendDrain backends get no new connections, the live ones are kept
+	BackendDrain = "drain"
+	//BackendDown backends get no traffic at all
+	BackendDown = "down"
+)
+
+// HealthCheck is a loadbalancer heath check of the backends, a raw TCP probe
+// with Send and Expect or an HTTP(S) request to Path expecting ExpectStatus.
+// The zero values are the defaults of the API.
+type HealthCheck struct {
+	Type   HealthCheckType `json:"type,omitempty"` //tcp if empty
+	Port   int32           `json:"port,omitempty"`
+	Send   string          `json:"send,omitempty"`
+	Expect string          `json:"expect,omitempty"`
+	//HTTP and HTTPS health checks only
+	Path         string `json:"path,omitempty"`
+	ExpectStatus int    `json:"expect_status,omitempty"`
+	//Seconds between the checks and before a check fails
+	Interval int `json:"interval,omitempty"`
+	Timeout  int `json:"timeout,omitempty"`
+	//Consecutive checks needed to mark a backend up or down
+	HealthyThreshold   int `json:"healthy_threshold,omitempty"`
+	UnhealthyThreshold int `json:"unhealthy_threshold,omitempty"`
+}
+
+// HealthCheckType is the kind of probe of a health check
+type HealthCheckType string
+
+// Types of health checks
+const (
+	HealthCheckTCP   HealthCheckType = "tcp"
+	HealthCheckHTTP  HealthCheckType = "http"
+	HealthCheckHTTPS HealthCheckType = "https"
+)
+
+//StatusError is an unexpected status returned by the API
+type StatusError struct {
+	StatusCode int
+	msg        string
+}
+
+func (e *StatusError) Error() string {
+	return e.msg
+}
+
+//statusError returns a StatusError with the status of res and a message
+func statusError(res *http.Response, format string, args ...interface{}) error {
+	return errors.WithStack(&StatusError{StatusCode: res.StatusCode, msg: fmt.Sprintf(format, args...)})
+}
+
+//prepare the http request and marchal the object to send
+func prepareRequest(obj Service, url, method string) (*http.Request, error) {
+	data, err := json.Marshal(obj)
+	if err != nil {
+		return nil, errors.Wrap(err, "error marshalling Frontend")
+	}
+	buf := bytes.NewBuffer(data)
+
+	req, err := http.NewRequest(method, url, buf)
+	if err != nil {
+		return nil, errors.Wrap(err, "error creatign http.Request")
+	}
+	req.Header.Set("Content-Type", jsonContent)
+	return req, nil
+}
+
+//Families of the ingress IPs
+const (
+	IPv4 = "IPv4"
+	IPv6 = "IPv6"
+)
+
+// ServiceType represent the type of service offered by the load balancers.
+type ServiceType string
+
+// Known types of service
+const (
+	TCP              ServiceType = "tcp"
+	UDP              ServiceType = "udp"
+	TCPProxyProtocol ServiceType = "tcp_proxy_protocol"
+)
@@ -0,0 +1,104 @@
+package lbapi
+
+import (
+	"io/ioutil"
+	"net/http"
+
+	"github.com/koki/json"
+	"github.com/pkg/errors"
+)
+
+//Pool of ingress IPs of the load balancers, with its usage. The services get
+//their ingress IPs from the first pool restricted to their namespace or class,
+//see SelectPool.
+type Pool struct {
+	Name string `json:"name"`
+	//Ranges of IPs, networks in CIDR form or ranges as 10.0.0.10-10.0.0.20
+	Ranges []string `json:"ranges"`
+	//Namespaces the pool is restricted to, all if empty
+	Namespaces []string `json:"namespaces,omitempty"`
+	//Classes the pool is restricted to, the loadBalancerClass of the services, all if empty
+	Classes []string `json:"classes,omitempty"`
+	//Usage per IP family, set by the API
+	Usage []PoolUsage `json:"usage,omitempty"`
+}
+
+//PoolUsage is the number of IPs of a family in a pool and how many are used
+type PoolUsage struct {
+	Family string `json:"family"`
+	Size   int    `json:"size"`
+	Used   int    `json:"used"`
+}
+
+//Free returns the number of free IPs in the pool of families, of any family if none
+func (p Pool) Free(families ...string) int {
+	free := 0
+	for _, u := range p.Usage {
+		if len(families) == 0 || contains(families, u.Family) {
+			free += u.Size - u.Used
+		}
+	}
+	return free
+}
+
+//restricted tells if the pool is restricted to some namespaces or classes
+func (p Pool) restricted() bool {
+	return len(p.Namespaces) > 0 || len(p.Classes) > 0
+}
+
+//Matches tells if the pool can be used by the services of namespace and class
+func (p Pool) Matches(namespace, class string) bool {
+	return (len(p.Namespaces) == 0 || contains(p.Namespaces, namespace)) &&
+		(len(p.Classes) == 0 || contains(p.Classes, class))
+}
+
+//SelectPool returns the pool of the services of namespace and class, the first
+//one restricted to them or else the first unrestricted one.
+func SelectPool(pools []Pool, namespace, class string) (Pool, bool) {
+	for _, p := range pools {
+		if p.restricted() && p.Matches(namespace, class) {
+			return p, true
+		}
+	}
+	for _, p := range pools {
+		if !p.restricted() {
+			return p, true
+		}
+	}
+	return Pool{}, false
+}
+
+//ListPools returns the pools of ingress IPs with their usage,
+//none if the API does not manage pools.
+//A token is needed to authenticate
+func ListPools(url, token string) ([]Pool, error) {
+	url = url + "/" + poolPath
+
+	req, err := newRequest(http.MethodGet, url, token, nil)
+	if err != nil {
+		return nil, errors.Wrap(err, "error creating http request")
+	}
+	res, err := http.DefaultClient.Do(req)
+	if err != nil {
+		return nil, errors.Wrapf(err, "error connecting to API endpoint: %s", url)
+	}
+	defer res.Body.Close()
+
+	body, err := ioutil.ReadAll(res.Body)
+	if err != nil {
+		return nil, errors.Wrapf(err, "error reading from API endpoint: %s", url)
+	}
+	switch res.StatusCode {
+	case http.StatusNotFound:
+		return nil, nil
+	case http.StatusOK:
+	default:
+		return nil, statusError(res, "error, returned status not 200 OK from API endpoint: %s", res.Status)
+	}
+
+	pools := []Pool{}
+	if err := json.Unmarshal(body, &pools); err != nil {
+		return nil, errors.Wrap(err, "error decoding Pool objects")
+	}
+	return pools, nil
+}
@@ -1,5 +1,4 @@
-// +build ignore
-package main
+package lbapi
 
 import (
 	"bytes"
@@ -20,6 +19,8 @@ type Service struct {
 	Config   Config                   `json:"config,omitempty"`
 	Ingress  []v1.LoadBalancerIngress `json:"ingress,omitempty"` //TODO(gta) make our own type and remove dependancy from k8s?
 
+	//Connections are the live connections per backend host, reported by the APIs supporting it
+	Connections map[string]int `json:"connections,omitempty"`
 }
 
 //ListServices return a list of services
@@ -39,7 +40,7 @@ func ListServices(url, token string) ([]Service, error) {
 	}
 
 	if res.StatusCode != http.StatusOK {
-		return nil, errors.Errorf("error, returned status not 200 OK from API endpoint: %s", res.Status)
+		return nil, statusError(res, "error, returned status not 200 OK from API endpoint: %s", res.Status)
 	}
 
 	dec := json.NewDecoder(res.Body)
@@ -99,7 +100,7 @@ func GetService(name, url, token string) (Service, bool, error) {
 		}
 
 	default:
-		return ret, false, errors.Errorf("error, returned status from API endpoint not supported: %s\n ", res.Status)
+		return ret, false, statusError(res, "error, returned status from API endpoint not supported: %s\n ", res.Status)
 	}
 
 	err = json.Unmarshal(body, &ret)
@@ -142,7 +143,7 @@ func SyncService(svc Service, url, token string) ([]v1.LoadBalancerIngress, erro
 	case http.StatusCreated, http.StatusOK:
 		//happy path
 	default:
-		return nil, errors.Errorf("API endpoint returned status %s, %s", res.Status, body)
+		return nil, statusError(res, "API endpoint returned status %s, %s", res.Status, body)
 	}
 
 	location := res.Header.Get("Location")
@@ -185,7 +186,7 @@ func DeleteService(name, url, token string) error {
 		if err != nil {
 			return errors.Wrapf(err, "error reading from API endpoint: %s", url)
 		}
-		return errors.Errorf("API endpoint returned status %s, %s", res.Status, bytes.TrimSpace(body))
+		return statusError(res, "API endpoint returned status %s, %s", res.Status, bytes.TrimSpace(body))
 	}
 
 	return nil
@@ -213,7 +214,7 @@ func getIngress(url string) ([]v1.LoadBalancerIngress, error) {
 	}
 
 	if res.StatusCode != http.StatusOK {
-		return nil, errors.Errorf("error, returned status from API endpoint not supported: %s\n ", res.Status)
+		return nil, statusError(res, "error, returned status from API endpoint not supported: %s\n ", res.Status)
 	}
 
 	err = json.Unmarshal(body, &ret)
@@ -0,0 +1,236 @@
+package lbapi
+
+import (
+	"bytes"
+	"reflect"
+	"testing"
+
+	"github.com/koki/json"
+)
+
+var TestServiceString = `{
+	"type": "tcp",
+	"metadata": {
+		"name": "testservice"
+	},
+	"config": {
+		"method": "least_conn",
+		"ports": {
+			"80": 443
+		},
+		"backends": [
+			{
+				"host": "hostname1.example.com",
+				"addrs": [
+					"10.3.2.43",
+					"2001:700:f00d::8"
+				]
+			},
+			{
+				"host": "hostname2.example.com",
+				"addrs": [
+					"10.3.2.53",
+					"2001:700:f00d::18"
+				]
+			}
+		],
+		"upstream_max_conns": 100,
+		"acl": [
+			"10.10.20.0/24",
+			"2001:700:1337::/48"
+		],
+		"health_check": {
+			"port": 1337,
+			"send": "healthz\n",
+			"expect": "^OK$"
+		},
+		"frontend": "foobar"
+	}
+}`
+
+var (
+	testServiceGo = Service{
+		Type: TCP,
+		Metadata: Metadata{
+			Name: "testservice",
+		},
+		Config: Config{
+			Method: "least_conn",
+			Ports:  map[string]int32{"80": 443},
+			Backends: []Backend{
+				{
+					Host:  "hostname1.example.com",
+					Addrs: []string{"10.3.2.43", "2001:700:f00d::8"},
+				},
+				{
+					Host:  "hostname2.example.com",
+					Addrs: []string{"10.3.2.53", "2001:700:f00d::18"},
+				},
+			},
+			UpstreamMaxConns: 100,
+			ACL:              []string{"10.10.20.0/24", "2001:700:1337::/48"},
+			HealthCheck: HealthCheck{
+				Port:   1337,
+				Send:   "healthz\n",
+				Expect: "^OK$",
+			},
+			Frontend: "foobar",
+		},
+	}
+)
+
+func TestUnmarshalService(t *testing.T) {
+
+	msg := new(Service)
+	err := json.Unmarshal([]byte(TestServiceString), msg)
+	if err != nil {
+		t.Errorf("TestUnmarshalTCPService() error umarshalling message = %v", err)
+		return
+	}
+
+	if !reflect.DeepEqual(*msg, testServiceGo) {
+		t.Errorf("TestUnmarshalTCPService() Type = %+v, want %+v", msg, testServiceGo)
+	}
+
+}
+
+//This might get a bit flaky...
+func TestMarshalTCPService(t *testing.T) {
+
+	j, err := json.Marshal(testServiceGo)
+	if err != nil {
+		t.Errorf("TestMarshalTCPService() error marshalling = %v", err)
+		return
+	}
+	var out bytes.Buffer
+	json.Indent(&out, j, "", "\t")
+	got := out.String()
+	want := TestServiceString
+	if want != got {
+		t.Errorf("TestMarshalTCPService() = %+v, want %+v", got, want)
+	}
+
+}
+
+func TestValidateService(t *testing.T) {
+	if err := testServiceGo.Validate(); err != nil {
+		t.Errorf("Validate() error = %v", err)
+	}
+	httpCheck := testServiceGo
+	httpCheck.Config.HealthCheck = HealthCheck{Type: HealthCheckHTTP, Port: 32000, Path: "/healthz", ExpectStatus: 200,
+		Interval: 5, Timeout: 2, HealthyThreshold: 2, UnhealthyThreshold: 3}
+	if err := httpCheck.Validate(); err != nil {
+		t.Errorf("Validate() of an HTTP health check error = %v", err)
+	}
+
+	tests := []struct {
+		name   string
+		modify func(s *Service)
+	}{
+		{"no name", func(s *Service) { s.Metadata.Name = "" }},
+		{"unknown type", func(s *Service) { s.Type = "http" }},
+		{"unknown method", func(s *Service) { s.Config.Method = "random" }},
+		{"negative affinity timeout", func(s *Service) { s.Config.Method, s.Config.AffinityTimeout = MethodSourceHash, -1 }},
+		{"affinity timeout without source hash", func(s *Service) { s.Config.AffinityTimeout = 60 }},
+		{"no ports", func(s *Service) { s.Config.Ports = nil }},
+		{"invalid port", func(s *Service) { s.Config.Ports = map[string]int32{"http": 443} }},
+		{"invalid backend port", func(s *Service) { s.Config.Ports = map[string]int32{"80": 0} }},
+		{"no backends", func(s *Service) { s.Config.Backends = nil }},
+		{"invalid backend address", func(s *Service) { s.Config.Backends = []Backend{{Host: "h", Addrs: []string{"h.example.com"}}} }},
+		{"invalid backend weight", func(s *Service) {
+			s.Config.Backends = []Backend{{Host: "h", Addrs: []string{"10.0.0.1"}, Weight: 1000}}
+		}},
+		{"negative backend max conns", func(s *Service) {
+			s.Config.Backends = []Backend{{Host: "h", Addrs: []string{"10.0.0.1"}, MaxConns: -1}}
+		}},
+		{"unknown backend state", func(s *Service) {
+			s.Config.Backends = []Backend{{Host: "h", Addrs: []string{"10.0.0.1"}, State: "maintenance"}}
+		}},
+		{"invalid acl", func(s *Service) { s.Config.ACL = []string{"10.10.20.0"} }},
+		{"invalid health check port", func(s *Service) { s.Config.HealthCheck.Port = 70000 }},
+		{"unknown health check type", func(s *Service) { s.Config.HealthCheck.Type = "grpc" }},
+		{"path of a TCP health check", func(s *Service) { s.Config.HealthCheck.Path = "/healthz" }},
+		{"send of an HTTP health check", func(s *Service) { s.Config.HealthCheck.Type = HealthCheckHTTP }},
+		{"relative health check path", func(s *Service) { s.Config.HealthCheck = HealthCheck{Type: HealthCheckHTTP, Path: "healthz"} }},
+		{"invalid health check status", func(s *Service) { s.Config.HealthCheck = HealthCheck{Type: HealthCheckHTTPS, ExpectStatus: 1000} }},
+		{"negative health check interval", func(s *Service) { s.Config.HealthCheck.Interval = -1 }},
+		{"health check timeout over interval", func(s *Service) { s.Config.HealthCheck.Interval, s.Config.HealthCheck.Timeout = 2, 5 }},
+		{"invalid requested IP", func(s *Service) { s.Config.RequestedIPs = []string{"10.0.0.0/24"} }},
+		{"requested IPs of the same family", func(s *Service) { s.Config.RequestedIPs = []string{"10.0.0.1", "10.0.0.2"} }},
+		{"invalid family", func(s *Service) { s.Config.IPFamilies = []string{"IPv5"} }},
+		{"repeated family", func(s *Service) { s.Config.IPFamilies = []string{IPv4, IPv4} }},
+		{"requested IP of another family", func(s *Service) {
+			s.Config.IPFamilies = []string{IPv6}
+			s.Config.RequestedIPs = []string{"10.0.0.1"}
+		}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			svc := testServiceGo
+			svc.Config.Ports = map[string]int32{"80": 443}
+			tt.modify(&svc)
+			if err := svc.Validate(); err == nil {
+				t.Errorf("Validate() should fail")
+			}
+		})
+	}
+}
+
+func TestCheckOwner(t *testing.T) {
+	owned := Service{Metadata: Metadata{Name: "a", Labels: map[string]string{LabelCluster: "nird"}}}
+	legacy := Service{Metadata: Metadata{Name: "b"}}
+
+	if err := owned.CheckOwner("nird"); err != nil {
+		t.Errorf("CheckOwner() of own service error = %v", err)
+	}
+	if err := owned.CheckOwner("other"); err == nil {
+		t.Errorf("CheckOwner() of a service of another cluster should fail")
+	}
+	if err := legacy.CheckOwner("nird"); err != nil {
+		t.Errorf("CheckOwner() of a service without owner error = %v", err)
+	}
+	if got := ClusterServices([]Service{owned, legacy}, "nird"); len(got) != 1 || got[0].Metadata.Name != "a" {
+		t.Errorf("ClusterServices() = %v, want only a", got)
+	}
+}
+
+func TestDrain(t *testing.T) {
+	svc := Service{Config: Config{Backends: []Backend{
+		{Host: "node1.example.com", Addrs: []string{"10.0.0.1"}},
+		{Host: "node2.example.com", Addrs: []string{"10.0.0.2"}},
+	}}}
+	if svc.SetBackendState("node3", BackendDrain) {
+		t.Errorf("SetBackendState() of a node without backends = true")
+	}
+	if !svc.SetBackendState("node1", BackendDrain) || !svc.SetBackendState("node2.example.com", BackendDown) {
+		t.Fatalf("SetBackendState() = false, want true")
+	}
+	if svc.Config.Backends[0].State != BackendDrain || svc.Config.Backends[1].State != BackendDown {
+		t.Errorf("SetBackendState() backends = %+v", svc.Config.Backends)
+	}
+	if got := svc.Metadata.Labels[LabelDrained]; got != "node1.example.com,node2.example.com" {
+		t.Errorf("SetBackendState() label = %q", got)
+	}
+	svc.SetBackendState("node2", "")
+	if got := svc.DrainedHosts(); !reflect.DeepEqual(got, []string{"node1.example.com"}) {
+		t.Errorf("DrainedHosts() = %v, want node1 only", got)
+	}
+
+	//the controller keeps the drains of the operators, not the other states
+	current := svc
+	current.Config.Backends = []Backend{
+		{Host: "node1.example.com", Addrs: []string{"10.0.0.1"}, State: BackendDrain},
+		{Host: "node2.example.com", Addrs: []string{"10.0.0.2"}, State: BackendDrain},
+	}
+	desired := Service{Config: Config{Backends: []Backend{
+		{Host: "node1.example.com", Addrs: []string{"10.0.0.1"}},
+		{Host: "node2.example.com", Addrs: []string{"10.0.0.2"}},
+	}}}
+	desired.KeepDrains(current)
+	if desired.Config.Backends[0].State != BackendDrain || desired.Config.Backends[1].State != "" {
+		t.Errorf("KeepDrains() backends = %+v", desired.Config.Backends)
+	}
+	if desired.Metadata.Labels[LabelDrained] != "node1.example.com" {
+		t.Errorf("KeepDrains() labels = %v", desired.Metadata.Labels)
+	}
+}
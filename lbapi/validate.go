@@ -0,0 +1,172 @@
+package lbapi
+
+import (
+	"net"
+	"regexp"
+	"strconv"
+	"strings"
+
+	"github.com/pkg/errors"
+)
+
+//Balancing methods of the API, source_hash keeps the clients on the same
+//backend for AffinityTimeout
+const (
+	MethodLeastConn  = "least_conn"
+	MethodRoundRobin = "round_robin"
+	MethodSourceHash = "source_hash"
+)
+
+//Methods are the balancing methods accepted by the API
+var Methods = []string{MethodLeastConn, MethodRoundRobin, MethodSourceHash}
+
+var nameRegexp = regexp.MustCompile(`^[a-zA-Z0-9]([-a-zA-Z0-9_.]{0,251}[a-zA-Z0-9])?$`)
+
+//Validate checks that the Service would be accepted by the API,
+//the errors match the ones returned by the API with status 400.
+func (s Service) Validate() error {
+	if !nameRegexp.MatchString(s.Metadata.Name) {
+		return errors.Errorf("metadata.name: invalid name %q", s.Metadata.Name)
+	}
+	switch s.Type {
+	case TCP, UDP, TCPProxyProtocol:
+	default:
+		return errors.Errorf("type: unknown service type %q", s.Type)
+	}
+	return errors.Wrap(s.Config.Validate(), "config")
+}
+
+//Validate checks that the Config would be accepted by the API
+func (c Config) Validate() error {
+	if c.Method != "" && !contains(Methods, c.Method) {
+		return errors.Errorf("method: unknown method %q", c.Method)
+	}
+	if c.AffinityTimeout < 0 {
+		return errors.Errorf("affinity_timeout: invalid value %d", c.AffinityTimeout)
+	}
+	if c.AffinityTimeout != 0 && c.Method != MethodSourceHash {
+		return errors.Errorf("affinity_timeout: only for the %s method", MethodSourceHash)
+	}
+	if len(c.Ports) == 0 {
+		return errors.New("ports: at least one port is required")
+	}
+	for port, target := range c.Ports {
+		p, err := strconv.Atoi(port)
+		if err != nil || !validPort(int32(p)) {
+			return errors.Errorf("ports: invalid port %q", port)
+		}
+		if !validPort(target) {
+			return errors.Errorf("ports.%s: invalid backend port %d", port, target)
+		}
+	}
+	if len(c.Backends) == 0 {
+		return errors.New("backends: at least one backend is required")
+	}
+	for i, b := range c.Backends {
+		if b.Host == "" {
+			return errors.Errorf("backends[%d].host: host is required", i)
+		}
+		if len(b.Addrs) == 0 {
+			return errors.Errorf("backends[%d].addrs: at least one address is required", i)
+		}
+		for _, a := range b.Addrs {
+			if net.ParseIP(a) == nil {
+				return errors.Errorf("backends[%d].addrs: invalid IP address %q", i, a)
+			}
+		}
+		if b.Weight < 0 || b.Weight > 256 {
+			return errors.Errorf("backends[%d].weight: invalid weight %d, must be between 1 and 256", i, b.Weight)
+		}
+		if b.MaxConns < 0 {
+			return errors.Errorf("backends[%d].max_conns: invalid value %d", i, b.MaxConns)
+		}
+		if b.State != "" && b.State != BackendDrain && b.State != BackendDown {
+			return errors.Errorf("backends[%d].state: unknown state %q", i, b.State)
+		}
+	}
+	if c.UpstreamMaxConns < 0 {
+		return errors.Errorf("upstream_max_conns: invalid value %d", c.UpstreamMaxConns)
+	}
+	for _, acl := range c.ACL {
+		if _, _, err := net.ParseCIDR(acl); err != nil {
+			return errors.Errorf("acl: invalid CIDR %q", acl)
+		}
+	}
+	if err := c.HealthCheck.Validate(); err != nil {
+		return errors.Wrap(err, "health_check")
+	}
+	seen := map[string]bool{}
+	for _, f := range c.IPFamilies {
+		if (f != IPv4 && f != IPv6) || seen[f] {
+			return errors.Errorf("ip_families: invalid or repeated family %q", f)
+		}
+		seen[f] = true
+	}
+	families := map[bool]bool{}
+	for _, a := range c.RequestedIPs {
+		ip := net.ParseIP(a)
+		if ip == nil {
+			return errors.Errorf("requested_ips: invalid IP address %q", a)
+		}
+		if families[ip.To4() != nil] {
+			return errors.Errorf("requested_ips: more than one IP address of the family of %s", a)
+		}
+		families[ip.To4() != nil] = true
+		if family := ipFamily(ip); len(c.IPFamilies) > 0 && !seen[family] {
+			return errors.Errorf("requested_ips: %s is not of the families of the service", a)
+		}
+	}
+	return nil
+}
+
+//Validate checks that the HealthCheck would be accepted by the API
+func (h HealthCheck) Validate() error {
+	if h.Port != 0 && !validPort(h.Port) {
+		return errors.Errorf("port: invalid port %d", h.Port)
+	}
+	switch h.Type {
+	case "", HealthCheckTCP:
+		if h.Path != "" || h.ExpectStatus != 0 {
+			return errors.New("path and expect_status are for HTTP health checks only")
+		}
+	case HealthCheckHTTP, HealthCheckHTTPS:
+		if h.Send != "" || h.Expect != "" {
+			return errors.New("send and expect are for TCP health checks only")
+		}
+		if h.Path != "" && !strings.HasPrefix(h.Path, "/") {
+			return errors.Errorf("path: %q must start with /", h.Path)
+		}
+		if h.ExpectStatus != 0 && (h.ExpectStatus < 100 || h.ExpectStatus > 599) {
+			return errors.Errorf("expect_status: invalid HTTP status %d", h.ExpectStatus)
+		}
+	default:
+		return errors.Errorf("type: unknown health check type %q", h.Type)
+	}
+	if h.Interval < 0 || h.Timeout < 0 || h.HealthyThreshold < 0 || h.UnhealthyThreshold < 0 {
+		return errors.New("interval, timeout and thresholds cannot be negative")
+	}
+	if h.Interval != 0 && h.Timeout > h.Interval {
+		return errors.Errorf("timeout: %ds is longer than the interval %ds", h.Timeout, h.Interval)
+	}
+	return nil
+}
+
+func ipFamily(ip net.IP) string {
+	if ip.To4() != nil {
+		return IPv4
+	}
+	return IPv6
+}
+
+func validPort(p int32) bool {
+	return p > 0 && p < 65536
+}
+
+func contains(list []string, s string) bool {
+	for _, l := range list {
+		if l == s {
+			return true
+		}
+	}
+	return false
+}
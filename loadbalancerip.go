@@ -0,0 +1,183 @@
+package main
+
+import (
+	"log"
+	"net"
+	"strings"
+
+	"github.com/UNINETT/lbcontroller/ippool"
+	"github.com/UNINETT/lbcontroller/lbapi"
+	"github.com/pkg/errors"
+	"k8s.io/api/core/v1"
+)
+
+//Reasons of the events about the ingress IPs
+const (
+	reasonInvalidIP  = "InvalidLoadBalancerIP"
+	reasonIPMismatch = "LoadBalancerIPMismatch"
+	reasonNoIP       = "IPPoolExhausted"
+)
+
+//requestedIPs returns the ingress IPs requested for ksvc, by annotation or
+//spec.loadBalancerIP. They must be inside the ranges of the ip-pool flag and
+//of the IP families of ksvc.
+func requestedIPs(ksvc v1.Service) ([]string, error) {
+	s, key := ksvc.Annotations[annotationLoadBalancerIPs], "annotation "+annotationLoadBalancerIPs
+	if _, ok := ksvc.Annotations[annotationLoadBalancerIPs]; !ok {
+		s, key = ksvc.Spec.LoadBalancerIP, "spec.loadBalancerIP"
+	}
+	if strings.TrimSpace(s) == "" {
+		return nil, nil
+	}
+
+	pool, err := ipPoolRanges()
+	if err != nil {
+		return nil, err
+	}
+	ret := []string{}
+	families := map[bool]bool{}
+	for _, f := range strings.Split(s, ",") {
+		ip := net.ParseIP(strings.TrimSpace(f))
+		if ip == nil {
+			return nil, errors.Errorf("%s: invalid IP address %q", key, f)
+		}
+		if families[ip.To4() != nil] {
+			return nil, errors.Errorf("%s: more than one IP address of the family of %s", key, ip)
+		}
+		families[ip.To4() != nil] = true
+		if !containsFold(ipFamilies(ksvc), ipFamily(ip)) {
+			return nil, errors.Errorf("%s: %s is not of the IP families of the service: %s", key, ip, strings.Join(ipFamilies(ksvc), ","))
+		}
+		if !pool.Contains(ip) {
+			return nil, errors.Errorf("%s: %s is not in the pool of the IPs that can be requested: %s", key, ip, strings.TrimSpace(*ipPool))
+		}
+		ret = append(ret, ip.String())
+	}
+	return ret, nil
+}
+
+//ipPoolRanges returns the pool of the ip-pool flag, a comma separated list of
+//networks, ranges and addresses, see ippool.ParseRange.
+func ipPoolRanges() (*ippool.Pool, error) {
+	ranges := []string{}
+	for _, r := range strings.Split(*ipPool, ",") {
+		if strings.TrimSpace(r) != "" {
+			ranges = append(ranges, r)
+		}
+	}
+	pool, err := ippool.New(ranges)
+	if err != nil {
+		return nil, errors.Wrap(err, "invalid IP pool")
+	}
+	return pool, nil
+}
+
+//missingIPs returns the requested IPs not assigned in ingress
+func missingIPs(requested []string, ingress []v1.LoadBalancerIngress) []string {
+	ret := []string{}
+	for _, r := range requested {
+		found := false
+		for _, in := range ingress {
+			if net.ParseIP(in.IP).Equal(net.ParseIP(r)) {
+				found = true
+			}
+		}
+		if !found {
+			ret = append(ret, r)
+		}
+	}
+	return ret
+}
+
+//checkPool returns an error if the pool of ingress IPs of ksvc has no free IP
+//of one of the families of ksvc.
+//The pools are read from the API, the check passes if it has none.
+func checkPool(ksvc v1.Service) error {
+	var pools []lbapi.Pool
+	err := lbendpoints.Do(func(url string) (err error) {
+		pools, err = lbapi.ListPools(url, *token)
+		return err
+	})
+	if err != nil {
+		//the API refuses the service anyway if there is no IP left
+		log.Printf("WARNING: cannot check the IP pools: %v\n", err)
+		return nil
+	}
+	if len(pools) == 0 {
+		return nil
+	}
+	class := ""
+	if ksvc.Spec.LoadBalancerClass != nil {
+		class = *ksvc.Spec.LoadBalancerClass
+	}
+	p, ok := lbapi.SelectPool(pools, ksvc.Namespace, class)
+	if !ok {
+		return errors.Errorf("no pool of ingress IPs for namespace %s", ksvc.Namespace)
+	}
+	for _, family := range ipFamilies(ksvc) {
+		if p.Free(family) <= 0 {
+			return errors.Errorf("the pool of ingress IPs %s has no free %s address", p.Name, family)
+		}
+	}
+	return nil
+}
+
+//ipFamilies returns the families of the ingress IPs of ksvc, from its
+//ipFamilyPolicy and ipFamilies: the first family for SingleStack, the
+//families listed for PreferDualStack and both for RequireDualStack, in the
+//order of ipFamilies. IPv4 is the family if none is listed.
+func ipFamilies(ksvc v1.Service) []string {
+	listed := []string{}
+	for _, f := range ksvc.Spec.IPFamilies {
+		if (f == v1.IPv4Protocol || f == v1.IPv6Protocol) && !containsFold(listed, string(f)) {
+			listed = append(listed, string(f))
+		}
+	}
+	if len(listed) == 0 {
+		listed = append(listed, lbapi.IPv4)
+	}
+	policy := v1.IPFamilyPolicySingleStack
+	if ksvc.Spec.IPFamilyPolicy != nil {
+		policy = *ksvc.Spec.IPFamilyPolicy
+	}
+	switch policy {
+	case v1.IPFamilyPolicyPreferDualStack:
+		return listed
+	case v1.IPFamilyPolicyRequireDualStack:
+		if len(listed) == 1 {
+			if listed[0] == lbapi.IPv4 {
+				return append(listed, lbapi.IPv6)
+			}
+			return append(listed, lbapi.IPv4)
+		}
+		return listed
+	default:
+		return listed[:1]
+	}
+}
+
+func ipFamily(ip net.IP) string {
+	if ip.To4() != nil {
+		return lbapi.IPv4
+	}
+	return lbapi.IPv6
+}
+
+//familyBackends returns the backends with their addresses of families only,
+//dropping the backends without any.
+func familyBackends(backends []lbapi.Backend, families []string) []lbapi.Backend {
+	ret := []lbapi.Backend{}
+	for _, b := range backends {
+		addrs := []string{}
+		for _, a := range b.Addrs {
+			if ip := net.ParseIP(a); ip != nil && containsFold(families, ipFamily(ip)) {
+				addrs = append(addrs, a)
+			}
+		}
+		if len(addrs) > 0 {
+			b.Addrs = addrs
+			ret = append(ret, b)
+		}
+	}
+	return ret
+}
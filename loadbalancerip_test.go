@@ -0,0 +1,268 @@
+package main
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/UNINETT/lbcontroller/lbapi"
+	"github.com/UNINETT/lbcontroller/test/lbcontrollertest"
+	"k8s.io/api/core/v1"
+	netv1 "k8s.io/api/networking/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+func TestRequestedIPs(t *testing.T) {
+	oldPool := *ipPool
+	defer func() { *ipPool = oldPool }()
+	*ipPool = "158.39.77.0/24, 158.39.78.10-158.39.78.20, 158.39.79.1, 2001:700:2::/64"
+
+	tests := []struct {
+		name       string
+		spec       string
+		annotation *string
+		dualStack  bool
+		want       []string
+		err        string
+	}{
+		{"none", "", nil, false, nil, ""},
+		{"spec", "158.39.77.10", nil, false, []string{"158.39.77.10"}, ""},
+		{"annotation dual-stack", "", strp("158.39.77.10, 2001:700:2::10"), true, []string{"158.39.77.10", "2001:700:2::10"}, ""},
+		{"annotation overrides spec", "158.39.77.10", strp("158.39.77.11"), false, []string{"158.39.77.11"}, ""},
+		{"outside the pool", "10.0.0.1", nil, false, nil, "not in the pool"},
+		{"range", "158.39.78.15", nil, false, []string{"158.39.78.15"}, ""},
+		{"outside the range", "158.39.78.21", nil, false, nil, "not in the pool"},
+		{"address", "158.39.79.1", nil, false, []string{"158.39.79.1"}, ""},
+		{"invalid", "", strp("158.39.77.300"), false, nil, "invalid IP address"},
+		{"same family", "", strp("158.39.77.10,158.39.77.11"), true, nil, "more than one IP address"},
+		{"single-stack", "", strp("158.39.77.10, 2001:700:2::10"), false, nil, "not of the IP families"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			ksvc := newTestKService(v1.ServiceTypeLoadBalancer, v1.ServicePort{Port: 80})
+			ksvc.Spec.LoadBalancerIP = tt.spec
+			if tt.dualStack {
+				ksvc = withFamilies(ksvc, v1.IPFamilyPolicyRequireDualStack)
+			}
+			if tt.annotation != nil {
+				ksvc.Annotations = map[string]string{annotationLoadBalancerIPs: *tt.annotation}
+			}
+			got, err := requestedIPs(ksvc)
+			if tt.err != "" {
+				if err == nil || !strings.Contains(err.Error(), tt.err) {
+					t.Errorf("requestedIPs() error = %v, want %q", err, tt.err)
+				}
+				return
+			}
+			if err != nil || !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("requestedIPs() = %v, %v, want %v", got, err, tt.want)
+			}
+		})
+	}
+
+	*ipPool = ""
+	ksvc := newTestKService(v1.ServiceTypeLoadBalancer, v1.ServicePort{Port: 80})
+	ksvc.Spec.LoadBalancerIP = "158.39.77.10"
+	if _, err := requestedIPs(ksvc); err == nil {
+		t.Errorf("requestedIPs() without pool should fail")
+	}
+}
+
+func strp(s string) *string {
+	return &s
+}
+
+func TestSyncRequestedIP(t *testing.T) {
+	srv := newTestAPI(t)
+	posted := newTestEvents(t)
+	oldPool := *ipPool
+	defer func() { *ipPool = oldPool }()
+	*ipPool = "127.0.0.0/24"
+
+	ksvc := newTestKService(v1.ServiceTypeLoadBalancer,
+		v1.ServicePort{Protocol: v1.ProtocolTCP, Port: 80, NodePort: 30080})
+	ksvc.Spec.LoadBalancerIP = "127.0.0.10"
+	if _, err := sync(&SyncRequest{Service: ksvc}); err != nil {
+		t.Fatalf("sync() error = %v", err)
+	}
+	svc, _ := srv.Service("nirddefaultnginxtcp")
+	if !reflect.DeepEqual(svc.Config.RequestedIPs, []string{"127.0.0.10"}) || svc.Ingress[0].IP != "127.0.0.10" {
+		t.Errorf("sync() service = %+v, want the requested IP", svc)
+	}
+	if len(*posted) != 0 {
+		t.Errorf("sync() events = %+v, want none", *posted)
+	}
+
+	//the IP is taken, the API assigns another one
+	ksvc.Name = "other"
+	response, err := sync(&SyncRequest{Service: ksvc})
+	if err != nil {
+		t.Fatalf("sync() error = %v", err)
+	}
+	if response.Annotations["nirddefaultothertcp.lb.example.com"] == "127.0.0.10" {
+		t.Errorf("sync() assigned a taken IP")
+	}
+	if len(*posted) != 1 || (*posted)[0].Reason != reasonIPMismatch || !strings.Contains((*posted)[0].Message, "requested 127.0.0.10") {
+		t.Errorf("sync() events = %+v, want a %s", *posted, reasonIPMismatch)
+	}
+
+	//outside the pool
+	ksvc.Name = "outside"
+	ksvc.Spec.LoadBalancerIP = "10.0.0.1"
+	if _, err := sync(&SyncRequest{Service: ksvc}); err != nil {
+		t.Fatalf("sync() error = %v", err)
+	}
+	srv.AssertNoService(t, "nirddefaultoutsidetcp")
+	if len(*posted) != 2 || (*posted)[1].Reason != reasonInvalidIP {
+		t.Errorf("sync() events = %+v, want a %s", *posted, reasonInvalidIP)
+	}
+
+	//an invalid IP requested later keeps the NetworkPolicy of the load balancer service
+	ksvc.Name = "other"
+	ksvc.Spec.LoadBalancerIP = ""
+	response, err = sync(&SyncRequest{Service: ksvc})
+	if err != nil {
+		t.Fatalf("sync() error = %v", err)
+	}
+	ksvc.Spec.LoadBalancerIP = "10.0.0.1"
+	response, err = sync(&SyncRequest{Service: ksvc, Attachments: attachments(response)})
+	if err != nil {
+		t.Fatalf("sync() error = %v", err)
+	}
+	if len(response.Attachments) != 1 || response.Attachments[0].Name != "other-lb" {
+		t.Errorf("sync() attachments = %+v, want the other-lb NetworkPolicy kept", response.Attachments)
+	}
+}
+
+func TestSyncPoolExhausted(t *testing.T) {
+	srv := newTestAPIWithOptions(t, lbcontrollertest.Options{Pools: []lbapi.Pool{
+		{Name: "web", Ranges: []string{"127.0.0.10/32"}, Namespaces: []string{"web"}},
+		{Name: "default", Ranges: []string{"127.0.0.0/24"}},
+	}})
+	posted := newTestEvents(t)
+
+	ksvc := newTestKService(v1.ServiceTypeLoadBalancer,
+		v1.ServicePort{Protocol: v1.ProtocolTCP, Port: 80, NodePort: 30080})
+	ksvc.Namespace = "web"
+	for _, name := range []string{"a", "b"} {
+		ksvc.Name = name
+		if _, err := sync(&SyncRequest{Service: ksvc}); err != nil {
+			t.Fatalf("sync() error = %v", err)
+		}
+	}
+	if svc, _ := srv.Service("nirdwebatcp"); len(svc.Ingress) != 1 || svc.Ingress[0].IP != "127.0.0.10" {
+		t.Errorf("ingress of a = %v, want from the web pool", svc.Ingress)
+	}
+	srv.AssertNoService(t, "nirdwebbtcp")
+	if len(*posted) != 1 || (*posted)[0].Reason != reasonNoIP || !strings.Contains((*posted)[0].Message, "web has no free IPv4") {
+		t.Errorf("sync() events = %+v, want a %s", *posted, reasonNoIP)
+	}
+	for _, r := range srv.Requests() {
+		if r.Method == "PUT" && r.Service == "nirdwebbtcp" {
+			t.Errorf("sync() tried to create b in an exhausted pool")
+		}
+	}
+
+	//the NetworkPolicies of a service missing in the API are kept
+	ksvc.Name = "b"
+	netpols := map[string]map[string]netv1.NetworkPolicy{"NetworkPolicy.networking.k8s.io/v1": {
+		"b-lb": {ObjectMeta: metav1.ObjectMeta{Name: "b-lb", Namespace: "web"}},
+	}}
+	response, err := sync(&SyncRequest{Service: ksvc, Attachments: netpols})
+	if err != nil {
+		t.Fatalf("sync() error = %v", err)
+	}
+	if len(response.Attachments) != 1 || response.Attachments[0].Name != "b-lb" {
+		t.Errorf("sync() attachments = %+v, want the b-lb NetworkPolicy kept", response.Attachments)
+	}
+
+	//existing services are updated anyway
+	ksvc.Name = "a"
+	ksvc.Spec.Ports[0].NodePort = 30081
+	if _, err := sync(&SyncRequest{Service: ksvc}); err != nil {
+		t.Fatalf("sync() error = %v", err)
+	}
+	if svc, _ := srv.Service("nirdwebatcp"); svc.Config.Ports["80"] != 30081 {
+		t.Errorf("sync() did not update a in an exhausted pool")
+	}
+}
+
+func withFamilies(ksvc v1.Service, policy v1.IPFamilyPolicyType, families ...v1.IPFamily) v1.Service {
+	ksvc.Spec.IPFamilyPolicy = &policy
+	ksvc.Spec.IPFamilies = families
+	return ksvc
+}
+
+func TestIPFamilies(t *testing.T) {
+	ksvc := newTestKService(v1.ServiceTypeLoadBalancer, v1.ServicePort{Port: 80})
+	tests := []struct {
+		name string
+		ksvc v1.Service
+		want []string
+	}{
+		{"default", ksvc, []string{lbapi.IPv4}},
+		{"single-stack v6", withFamilies(ksvc, v1.IPFamilyPolicySingleStack, v1.IPv6Protocol), []string{lbapi.IPv6}},
+		{"single-stack both", withFamilies(ksvc, v1.IPFamilyPolicySingleStack, v1.IPv6Protocol, v1.IPv4Protocol), []string{lbapi.IPv6}},
+		{"prefer dual-stack one", withFamilies(ksvc, v1.IPFamilyPolicyPreferDualStack, v1.IPv4Protocol), []string{lbapi.IPv4}},
+		{"prefer dual-stack both", withFamilies(ksvc, v1.IPFamilyPolicyPreferDualStack, v1.IPv6Protocol, v1.IPv4Protocol), []string{lbapi.IPv6, lbapi.IPv4}},
+		{"require dual-stack", withFamilies(ksvc, v1.IPFamilyPolicyRequireDualStack), []string{lbapi.IPv4, lbapi.IPv6}},
+		{"require dual-stack v6 first", withFamilies(ksvc, v1.IPFamilyPolicyRequireDualStack, v1.IPv6Protocol), []string{lbapi.IPv6, lbapi.IPv4}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := ipFamilies(tt.ksvc); !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("ipFamilies() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestFamilyBackends(t *testing.T) {
+	in := []lbapi.Backend{
+		{Host: "a", Addrs: []string{"10.0.0.1", "fd00::1"}},
+		{Host: "b", Addrs: []string{"fd00::2"}},
+	}
+	got := familyBackends(in, []string{lbapi.IPv4})
+	want := []lbapi.Backend{{Host: "a", Addrs: []string{"10.0.0.1"}}}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("familyBackends() = %v, want %v", got, want)
+	}
+	if got := familyBackends(in, []string{lbapi.IPv4, lbapi.IPv6}); !reflect.DeepEqual(got, in) {
+		t.Errorf("familyBackends() = %v, want %v", got, in)
+	}
+}
+
+func TestSyncDualStack(t *testing.T) {
+	srv := newTestAPIWithOptions(t, lbcontrollertest.Options{Pools: []lbapi.Pool{
+		{Name: "default", Ranges: []string{"127.0.0.0/24", "fd00::/120"}},
+	}})
+
+	ksvc := withFamilies(newTestKService(v1.ServiceTypeLoadBalancer,
+		v1.ServicePort{Protocol: v1.ProtocolTCP, Port: 80, NodePort: 30080}),
+		v1.IPFamilyPolicyRequireDualStack, v1.IPv6Protocol, v1.IPv4Protocol)
+	response, err := sync(&SyncRequest{Service: ksvc})
+	if err != nil {
+		t.Fatalf("sync() error = %v", err)
+	}
+	svc, _ := srv.Service("nirddefaultnginxtcp")
+	if !reflect.DeepEqual(svc.Config.IPFamilies, []string{lbapi.IPv6, lbapi.IPv4}) {
+		t.Errorf("ip_families = %v, want IPv6 and IPv4", svc.Config.IPFamilies)
+	}
+	if !reflect.DeepEqual(svc.Config.Backends, backends) {
+		t.Errorf("backends = %v, want the addresses of both families", svc.Config.Backends)
+	}
+	if response.Status == nil || len(response.Status.LoadBalancer.Ingress) != 2 ||
+		response.Status.LoadBalancer.Ingress[0].IP != "fd00::1" || response.Status.LoadBalancer.Ingress[1].IP != "127.0.0.1" {
+		t.Errorf("sync() status = %+v, want an ingress per family", response.Status)
+	}
+
+	//back to single-stack, the IPv6 ingress is released
+	ksvc = withFamilies(ksvc, v1.IPFamilyPolicySingleStack, v1.IPv4Protocol)
+	response, err = sync(&SyncRequest{Service: ksvc})
+	if err != nil {
+		t.Fatalf("sync() error = %v", err)
+	}
+	if len(response.Status.LoadBalancer.Ingress) != 1 || response.Status.LoadBalancer.Ingress[0].IP != "127.0.0.1" {
+		t.Errorf("sync() status = %+v, want the IPv4 ingress only", response.Status)
+	}
+}
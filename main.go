@@ -2,34 +2,67 @@ package main
 
 import (
 	"encoding/json"
+	"expvar"
 	"fmt"
 	"gopkg.in/alecthomas/kingpin.v2"
 	"io/ioutil"
 	"log"
 	"net/http"
 	"os"
+	"sort"
 	"strings"
+	"text/template"
 
 	//"github.com/koki/json"
-	"k8s.io/apimachinery/pkg/util/intstr"
 	//"k8s.io/apimachinery/pkg/util/json"
 
+	"github.com/UNINETT/lbcontroller/lbapi"
 	"github.com/gorilla/handlers"
 	"github.com/gorilla/mux"
 	"github.com/pkg/errors"
 	"k8s.io/api/core/v1"
 	netv1 "k8s.io/api/networking/v1"
-	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
 )
 
 const defaultCluster = "nird"
 
+//defaultClass is the default loadBalancerClass of the Services managed by the controller
+const defaultClass = "lbcontroller.uninett.no/lb"
+
 var (
-	lbpeersString = kingpin.Flag("peers", "The load babalancers IPs, comma separated in CIDR form").Required().Envar("LBC_PEERS").String()
-	lbendpoint    = kingpin.Flag("endpoint", "The load balancer controller API endpoint").Required().Envar("LBC_ENDPOINT").String()
+	lbpeersString = kingpin.Flag("peers", "The load babalancers IPs or CIDRs, comma separated, IPv4 and IPv6").Envar("LBC_PEERS").String()
+	peersFile     = kingpin.Flag("peers-file", "File with the load balancers IPs or CIDRs, e.g. from a mounted ConfigMap").Envar("LBC_PEERS_FILE").String()
+	peersReload   = kingpin.Flag("peers-reload", "How often to check the peers file for changes, 0 disables reloading").Default("30s").Envar("LBC_PEERS_RELOAD").Duration()
+	netpolEnabled = kingpin.Flag("netpol", "Generate a NetworkPolicy admitting the load balancers, can be overridden per service by annotation").Default("true").Envar("LBC_NETPOL").Bool()
+	netpolName    = kingpin.Flag("netpol-name", "Template of the NetworkPolicy name, the fields of the Service are available, e.g. {{.Namespace}}").Default(defaultNetpolName).Envar("LBC_NETPOL_NAME").String()
+	netpolLabels  = kingpin.Flag("netpol-label", "Extra label of the NetworkPolicy in key=value form, can be repeated").Envar("LBC_NETPOL_LABELS").StringMap()
+	netpolSkipAll = kingpin.Flag("netpol-skip-empty-selector", "Do not generate a NetworkPolicy, that would select all pods, for services without selector").Default("true").Envar("LBC_NETPOL_SKIP_EMPTY_SELECTOR").Bool()
+	netpolRanges  = kingpin.Flag("netpol-source-ranges", "Admit the loadBalancerSourceRanges in the NetworkPolicy of services preserving the client IPs").Envar("LBC_NETPOL_SOURCE_RANGES").Bool()
+	lbclass       = kingpin.Flag("class", "The loadBalancerClass of the Services managed by the controller").Default(defaultClass).Envar("LBC_CLASS").String()
+	lbunclassed   = kingpin.Flag("unclassed", "Manage the LoadBalancer Services without loadBalancerClass too, disable it when another implementation handles them").Default("true").Envar("LBC_UNCLASSED").Bool()
+	checkPools    = kingpin.Flag("check-pools", "Check that the pool of ingress IPs of a new service is not exhausted before creating it").Default("true").Envar("LBC_CHECK_POOLS").Bool()
+	ipPool        = kingpin.Flag("ip-pool", "Networks, ranges as 10.0.0.10-10.0.0.20 and addresses the Services can request their ingress IPs from, comma separated, requests are refused if empty").Envar("LBC_IP_POOL").String()
+	lbendpoint    = kingpin.Flag("endpoint", "The load balancer controller API endpoints, comma separated, e.g. one per site").Required().Envar("LBC_ENDPOINT").String()
+	endpointMode  = kingpin.Flag("endpoint-mode", "How the endpoints are selected, failover uses the first healthy one").Default(lbapi.Failover).Envar("LBC_ENDPOINT_MODE").Enum(lbapi.Failover, lbapi.RoundRobin)
+	endpointDown  = kingpin.Flag("endpoint-backoff", "How long an endpoint failing with a connection error, a 5xx or 429 answer is avoided").Default("30s").Envar("LBC_ENDPOINT_BACKOFF").Duration()
 	cluster       = kingpin.Flag("clustername", "The name of the Kubernetes cluster").Default("nird").Envar("LBC_CLUSTER_NAME").String()
 	token         = kingpin.Flag("token", "Authentication token to access the load balancer API").Required().Envar("LBC_TOKEN").String()
-	lbpeers       []string // split strings of lbpeersString
+	policyFile    = kingpin.Flag("policy-file", "File with the policy of the LoadBalancer Services of the namespaces, e.g. from a mounted ConfigMap").Envar("LBC_POLICY_FILE").String()
+	webhookListen = kingpin.Flag("webhook-listen", "Address of the HTTPS server of the validating admission webhook").Default(":8443").Envar("LBC_WEBHOOK_LISTEN").String()
+	tlsCert       = kingpin.Flag("tls-cert", "Certificate of the validating admission webhook, the webhook is disabled if empty").Envar("LBC_TLS_CERT").String()
+	tlsKey        = kingpin.Flag("tls-key", "Private key of the validating admission webhook").Envar("LBC_TLS_KEY").String()
+	kubeServer    = kingpin.Flag("kube-server", "The Kubernetes API server, e.g. http://127.0.0.1:8001 for kubectl proxy, the in-cluster configuration is used if empty").Envar("LBC_KUBE_SERVER").String()
+	kubeToken     = kingpin.Flag("kube-token", "Token to access the Kubernetes API, the one of the service account is used if empty").Envar("LBC_KUBE_TOKEN").String()
+	resyncEvery   = kingpin.Flag("resync", "How often the load balancer services of all the Services are checked and their drift corrected, 0 disables it").Default("10m").Envar("LBC_RESYNC").Duration()
+	probeChecks   = kingpin.Flag("probe-health-checks", "Derive the health checks from the readiness probes of the pods, can be overridden per service by annotation").Envar("LBC_PROBE_HEALTH_CHECKS").Bool()
+	lbpeers       = &peerSet{} // parsed and normalised peers from lbpeersString and peersFile
+	lbendpoints   *lbapi.Endpoints
+	kube          *kubeClient //nil if the Kubernetes API is not available
+
+	serveCmd  = kingpin.Command("serve", "Serve the sync hook called by the metacontroller.").Default()
+	planCmd   = kingpin.Command("plan", "Show the changes the controller would make to the load balancers, without applying them.")
+	planFiles = planCmd.Flag("filename", "Manifests with the Services to plan for, instead of reading them from the cluster, can be repeated").Short('f').Strings()
+	driftCmd  = kingpin.Command("drift", "Show the differences between the Services of the cluster and the load balancers.")
 )
 
 func init() {
@@ -38,16 +71,88 @@ func init() {
 }
 
 func main() {
-	kingpin.Parse()
+	cmd := kingpin.Parse()
+	if err := loadEndpoints(); err != nil {
+		log.Fatalf("ERROR: %v\n", err)
+	}
+	switch cmd {
+	case planCmd.FullCommand():
+		if err := plan(os.Stdout, *planFiles); err != nil {
+			log.Fatalf("ERROR: %v\n", err)
+		}
+	case driftCmd.FullCommand():
+		if err := drift(os.Stdout); err != nil {
+			log.Fatalf("ERROR: %v\n", err)
+		}
+	default:
+		serve()
+	}
+}
+
+func serve() {
+	peers, err := loadPeers(*lbpeersString, *peersFile)
+	if err != nil {
+		log.Fatalf("ERROR: %v\n", err)
+	}
+	lbpeers.set(peers)
+	log.Printf("load balancer peers: %v\n", peers)
+	if *peersFile != "" && *peersReload > 0 {
+		go watchPeersFile(*lbpeersString, *peersFile, *peersReload)
+	}
+
+	if *policyFile != "" {
+		p, err := loadPolicy(*policyFile)
+		if err != nil {
+			log.Fatalf("ERROR: %v\n", err)
+		}
+		lbpolicy.Store(p)
+		if *peersReload > 0 {
+			go watchPolicyFile(*policyFile, *peersReload)
+		}
+	}
+
+	if kc, err := newKubeClient(*kubeServer, *kubeToken); err != nil {
+		log.Printf("WARNING: events are only logged and the readiness probes are not read: %v\n", err)
+	} else {
+		kube, events.kube = kc, kc
+	}
+
+	netpolNameTemplate, err = template.New("netpol-name").Parse(*netpolName)
+	if err != nil {
+		log.Fatalf("ERROR: invalid NetworkPolicy name template: %v\n", err)
+	}
+
+	if *resyncEvery > 0 {
+		if kube == nil {
+			log.Println("WARNING: the resync needs the Kubernetes API, disabled")
+		} else {
+			go resyncLoop(*resyncEvery)
+		}
+	}
 
-	lbpeers = strings.Split(*lbpeersString, ",")
+	if *tlsCert != "" {
+		webhook := mux.NewRouter()
+		webhook.HandleFunc("/validate", admissionHandler).Methods("POST")
+		go func() {
+			log.Fatal(http.ListenAndServeTLS(*webhookListen, *tlsCert, *tlsKey, handlers.LoggingHandler(os.Stdout, webhook)))
+		}()
+	}
 
 	router := mux.NewRouter()
 	router.HandleFunc("/sync", syncHandler).Methods("POST")
+	router.Handle("/debug/vars", expvar.Handler())
+	router.HandleFunc("/debug/drift", driftHandler).Methods("GET")
 	loggedRouter := handlers.LoggingHandler(os.Stdout, router)
 	log.Fatal(http.ListenAndServe(":8080", loggedRouter))
 }
 
+//loadEndpoints sets lbendpoints from the endpoint flags
+func loadEndpoints() error {
+	var err error
+	lbendpoints, err = lbapi.NewEndpoints(strings.Split(*lbendpoint, ","), *endpointMode, *endpointDown)
+	return err
+}
+
 //SyncRequest is the request from the metacontroller
 type SyncRequest struct {
 	Controller  json.RawMessage                           `json:"controller"`
@@ -60,16 +165,21 @@ type SyncResponse struct {
 	Labels      map[string]string     `json:"labels"`
 	Annotations map[string]string     `json:"annotations"`
 	Attachments []netv1.NetworkPolicy `json:"attachments"`
+	Status      *v1.ServiceStatus     `json:"status,omitempty"`
 }
 
+//reasonOtherCluster is the reason of the events about the Services whose load
+//balancer service is owned by another cluster sharing the API
+const reasonOtherCluster = "OwnedByOtherCluster"
+
 func sync(request *SyncRequest) (*SyncResponse, error) {
 	response := &SyncResponse{}
 	response.Labels = make(map[string]string)
 	response.Annotations = make(map[string]string)
 	response.Attachments = make([]netv1.NetworkPolicy, 0, 1)
 
-	if request.Service.Spec.Type != v1.ServiceTypeLoadBalancer {
-		log.Println("not a loadbalancer service")
+	if !managedService(request.Service) {
+		log.Println("not a loadbalancer service of this controller")
 		//TODO (gta) empty response? keep labels and annotations!
 		return response, nil
 	}
@@ -84,40 +194,147 @@ func sync(request *SyncRequest) (*SyncResponse, error) {
 		return response, nil
 	}
 
-	var (
-		namespace   = request.Service.Namespace
-		serviceName = request.Service.Name
-	)
-	//serviceLbKey := strings.Join([]string{*cluster, namespace, serviceName, protoString}, "-")
-	serviceLbKey := strings.Join([]string{*cluster, namespace, serviceName, protoString}, "")
+	serviceLbKey := serviceKey(request.Service, protoString)
+
+	if np := currentPolicy().forNamespace(request.Service.Namespace); np != nil {
+		services := 0
+		if np.MaxServices > 0 {
+			services, err = countNamespaceServices(request.Service.Namespace, serviceLbKey)
+			if err != nil {
+				return response, err
+			}
+		}
+		if err := np.check(request.Service, svcProto, svcPorts, services); err != nil {
+			events.event(request.Service, v1.EventTypeWarning, "PolicyViolation", "Service rejected by the load balancer policy: "+err.Error())
+			response.Labels[labelPolicy] = "rejected"
+			response.Annotations[annotationPolicyViolation] = err.Error()
+			//the load balancer service created before is kept, so are its NetworkPolicies
+			response.Attachments = currentAttachments(request)
+			return response, nil
+		}
+		events.forget(request.Service, "PolicyViolation")
+		response.Labels[labelPolicy] = "allowed"
+		response.Annotations[annotationPolicyViolation] = ""
+	} else {
+		//no policy anymore, clear the verdict of the one before
+		response.Labels[labelPolicy] = ""
+		response.Annotations[annotationPolicyViolation] = ""
+	}
+
+	requested, err := requestedIPs(request.Service)
+	if err != nil {
+		events.event(request.Service, v1.EventTypeWarning, reasonInvalidIP, err.Error())
+		response.Attachments = currentAttachments(request)
+		return response, nil
+	}
+	events.forget(request.Service, reasonInvalidIP)
 
 	log.Println("sync load balancer service")
 
-	lbService := newlbcontrollerService(request.Service, serviceLbKey, protoString)
+	netpols, err := networkPolicies(request.Service, svcProto, svcPorts)
+	if err != nil {
+		log.Printf("ERROR: %v\n", err)
+		events.event(request.Service, v1.EventTypeWarning, reasonInvalidNetpol, err.Error())
+		response.Attachments = currentAttachments(request)
+		return response, nil
+	}
+	events.forget(request.Service, reasonInvalidNetpol)
+
+	if _, err := probeChecksEnabled(request.Service); err != nil {
+		events.event(request.Service, v1.EventTypeWarning, reasonInvalidProbeCheck, err.Error())
+		response.Attachments = currentAttachments(request)
+		return response, nil
+	}
+	events.forget(request.Service, reasonInvalidProbeCheck)
+
+	//fail, so the metacontroller retries, rather than undrain the nodes
+	lbService, err := desiredService(request.Service, serviceLbKey, protoString)
+	if err != nil {
+		return response, err
+	}
 
-	ingress, err := SyncService(lbService, *lbendpoint, *token)
+	//never touch the load balancer services of the other clusters sharing the API
+	var (
+		current lbapi.Service
+		found   bool
+	)
+	err = lbendpoints.Do(func(url string) (err error) {
+		current, found, err = lbapi.GetService(serviceLbKey, url, *token)
+		return err
+	})
+	if err != nil {
+		return response, errors.Wrap(err, "Could not get load balancer service")
+	}
+	if found {
+		if err := current.CheckOwner(*cluster); err != nil {
+			log.Printf("ERROR: %v, not updating it\n", err)
+			events.event(request.Service, v1.EventTypeWarning, reasonOtherCluster, err.Error()+", not updating it")
+			response.Attachments = currentAttachments(request)
+			return response, nil
+		}
+		events.forget(request.Service, reasonOtherCluster)
+		lbService.KeepDrains(current)
+	}
+	if !found && *checkPools {
+		if err := checkPool(request.Service); err != nil {
+			events.event(request.Service, v1.EventTypeWarning, reasonNoIP, err.Error())
+			//e.g. the load balancer service was lost by the API, keep the NetworkPolicies until it is back
+			response.Attachments = currentAttachments(request)
+			return response, nil
+		}
+		events.forget(request.Service, reasonNoIP)
+	}
+
+	var ingress []v1.LoadBalancerIngress
+	err = lbendpoints.Do(func(url string) (err error) {
+		ingress, err = lbapi.SyncService(lbService, url, *token)
+		return err
+	})
 	if err != nil {
 		return response, errors.Wrap(err, "Could not create load balancer service")
 	}
 
 	log.Printf("Created/updated load balancer with ingress: %v\n", ingress)
 
+	if missing := missingIPs(requested, ingress); len(missing) > 0 {
+		assigned := []string{}
+		for _, in := range ingress {
+			assigned = append(assigned, in.IP)
+		}
+		events.event(request.Service, v1.EventTypeWarning, reasonIPMismatch,
+			fmt.Sprintf("requested %s, the load balancer assigned %s", strings.Join(missing, ","), strings.Join(assigned, ",")))
+	} else {
+		events.forget(request.Service, reasonIPMismatch)
+	}
+
+	response.Status = &v1.ServiceStatus{LoadBalancer: v1.LoadBalancerStatus{Ingress: ingress}}
+
 	//TODO this annotation might be not optimal
 	for _, in := range ingress {
 		response.Annotations[in.Hostname] = in.IP
 	}
 
-	log.Println("generate NetworkPolicy")
-
-	netpol := newNetworkPolicy(request.Service, ingress, svcProto, svcPorts)
-
 	response.Labels["LoadBalncer"] = "true" //TODO change this in something more useful?
 
-	response.Attachments = append(response.Attachments, netpol)
+	response.Attachments = append(response.Attachments, netpols...)
 
 	return response, nil
 }
 
+//currentAttachments returns the NetworkPolicies attached to the Service of
+//request, sorted by name, to keep them when the Service is refused but its
+//load balancer service is kept. Returning none would delete them.
+func currentAttachments(request *SyncRequest) []netv1.NetworkPolicy {
+	ret := make([]netv1.NetworkPolicy, 0, 1)
+	for _, netpols := range request.Attachments {
+		for _, np := range netpols {
+			ret = append(ret, np)
+		}
+	}
+	sort.Slice(ret, func(i, j int) bool { return ret[i].Name < ret[j].Name })
+	return ret
+}
+
 func syncHandler(w http.ResponseWriter, r *http.Request) {
 	body, err := ioutil.ReadAll(r.Body)
 	if err != nil {
@@ -148,11 +365,29 @@ func syncHandler(w http.ResponseWriter, r *http.Request) {
 	w.Write(body)
 }
 
-func syncLoadBalancerService(v1.Service, Service) error {
+func syncLoadBalancerService(v1.Service, lbapi.Service) error {
 	log.Printf("TODO syncLoadBalancerService")
 	return nil
 }
 
+//managedService tells if ksvc is a LoadBalancer Service of this controller,
+//according to its loadBalancerClass.
+func managedService(ksvc v1.Service) bool {
+	if ksvc.Spec.Type != v1.ServiceTypeLoadBalancer {
+		return false
+	}
+	if ksvc.Spec.LoadBalancerClass == nil {
+		return *lbunclassed
+	}
+	return *ksvc.Spec.LoadBalancerClass == *lbclass
+}
+
+//serviceKey returns the name of the load balancer service of a Kubernetes Service
+func serviceKey(ksvc v1.Service, protocol string) string {
+	//serviceLbKey := strings.Join([]string{*cluster, namespace, serviceName, protoString}, "-")
+	return strings.Join([]string{*cluster, ksvc.Namespace, ksvc.Name, protocol}, "")
+}
+
 //TODO(gta) if there are both tcp and udp specified log an error and do nothing.
 func getPortsProto(service v1.Service) ([]int32, v1.Protocol, error) {
 	var (
@@ -177,19 +412,37 @@ func getPortsProto(service v1.Service) ([]int32, v1.Protocol, error) {
 	return svcPorts, svcProto, nil
 }
 
-func newlbcontrollerService(ks v1.Service, key, protocol string) Service {
-	svc := Service{}
-	svc.Type = ServiceType(protocol)
+func newlbcontrollerService(ks v1.Service, key, protocol string) lbapi.Service {
+	svc := lbapi.Service{}
+	svc.Type = lbapi.ServiceType(protocol)
 	svc.Metadata.Name = key
-	cfg := Config{
-		Method:           "least_conn",
+	svc.Metadata.Labels = map[string]string{
+		lbapi.LabelCluster:   *cluster,
+		lbapi.LabelNamespace: ks.Namespace,
+		lbapi.LabelService:   ks.Name,
+	}
+	if ks.Spec.LoadBalancerClass != nil {
+		svc.Metadata.Labels[lbapi.LabelClass] = *ks.Spec.LoadBalancerClass
+	}
+	cfg := lbapi.Config{
+		Method:           lbapi.MethodLeastConn,
 		UpstreamMaxConns: 100,
 	}
-	cfg.Backends = backends //TODO this is hardcoded for now
+	if ks.Spec.SessionAffinity == v1.ServiceAffinityClientIP {
+		cfg.Method = lbapi.MethodSourceHash
+		cfg.AffinityTimeout = int(v1.DefaultClientIPServiceAffinitySeconds)
+		if c := ks.Spec.SessionAffinityConfig; c != nil && c.ClientIP != nil && c.ClientIP.TimeoutSeconds != nil {
+			cfg.AffinityTimeout = int(*c.ClientIP.TimeoutSeconds)
+		}
+	}
+	cfg.IPFamilies = ipFamilies(ks)
+	cfg.Backends = familyBackends(backends, cfg.IPFamilies) //TODO this is hardcoded for now
 	if len(ks.Spec.LoadBalancerSourceRanges) != 0 {
 		cfg.ACL = ks.Spec.LoadBalancerSourceRanges
 	}
-	if ks.Spec.HealthCheckNodePort != 0 {
+	if ks.Spec.ExternalTrafficPolicy == v1.ServiceExternalTrafficPolicyTypeLocal && ks.Spec.HealthCheckNodePort != 0 {
+		cfg.HealthCheck = kubeProxyHealthCheck(ks.Spec.HealthCheckNodePort)
+	} else if ks.Spec.HealthCheckNodePort != 0 {
 		cfg.HealthCheck.Port = ks.Spec.HealthCheckNodePort
 	} else if len(ks.Spec.Ports) > 0 {
 		cfg.HealthCheck.Port = ks.Spec.Ports[0].NodePort
@@ -197,65 +450,55 @@ func newlbcontrollerService(ks v1.Service, key, protocol string) Service {
 
 	cfg.Ports = make(map[string]int32)
 	for _, p := range ks.Spec.Ports {
-		if string(p.Protocol) == protocol {
+		proto := p.Protocol
+		if proto == "" {
+			proto = v1.ProtocolTCP //default of the API server
+		}
+		if strings.EqualFold(string(proto), protocol) {
 			port := fmt.Sprint(p.Port)
 			cfg.Ports[port] = int32(p.NodePort)
 		}
 	}
+	cfg.RequestedIPs, _ = requestedIPs(ks) //invalid requests are refused by sync before
+	svc.Config = cfg
 
 	return svc
 }
 
-func newNetworkPolicy(ksvc v1.Service, ingress []v1.LoadBalancerIngress, proto v1.Protocol, ports []int32) netv1.NetworkPolicy {
-
-	netPolPorts := []netv1.NetworkPolicyPort{}
-	for _, p := range ports {
-		port := netv1.NetworkPolicyPort{
-			Protocol: &proto,
-			Port: &intstr.IntOrString{
-				Type:   intstr.Int,
-				IntVal: p,
-			},
-		}
-		netPolPorts = append(netPolPorts, port)
+//desiredService returns the load balancer service wanted for ks, as
+//newlbcontrollerService with the checks and the states read from the cluster:
+//the readiness probes of the pods and the nodes in maintenance.
+func desiredService(ks v1.Service, key, protocol string) (lbapi.Service, error) {
+	svc := newlbcontrollerService(ks, key, protocol)
+	if hc, ok := probeHealthCheck(ks, protocol); ok {
+		svc.Config.HealthCheck = hc
 	}
+	backends, err := drainNodes(svc.Config.Backends)
+	if err != nil {
+		return lbapi.Service{}, err
+	}
+	svc.Config.Backends = backends
+	return svc, nil
+}
 
-	netPolPeers := []netv1.NetworkPolicyPeer{}
-	for _, peer := range lbpeers {
-		netin := netv1.NetworkPolicyPeer{
-			IPBlock: &netv1.IPBlock{
-				CIDR: peer,
-			},
-		}
-		netPolPeers = append(netPolPeers, netin)
-	}
-
-	netpol := netv1.NetworkPolicy{
-		TypeMeta: metav1.TypeMeta{
-			APIVersion: "networking.k8s.io/v1",
-			Kind:       "NetworkPolicy",
-		},
-		ObjectMeta: metav1.ObjectMeta{
-			Name: ksvc.Name + "-lb",
-		},
-		Spec: netv1.NetworkPolicySpec{
-			PolicyTypes: []netv1.PolicyType{netv1.PolicyTypeIngress},
-			PodSelector: metav1.LabelSelector{
-				MatchLabels: ksvc.Spec.Selector,
-			},
-			Ingress: []netv1.NetworkPolicyIngressRule{
-				{
-					Ports: netPolPorts,
-					From:  netPolPeers,
-				},
-			},
-		},
-	}
-	return netpol
+//kubeProxyHealthCheck returns the health check of the healthz endpoint of
+//kube-proxy on port, the HealthCheckNodePort of the services with
+//externalTrafficPolicy Local. It fails on the nodes without endpoints.
+func kubeProxyHealthCheck(port int32) lbapi.HealthCheck {
+	return lbapi.HealthCheck{
+		Type:               lbapi.HealthCheckHTTP,
+		Port:               port,
+		Path:               "/healthz",
+		ExpectStatus:       http.StatusOK,
+		Interval:           5,
+		Timeout:            2,
+		HealthyThreshold:   2,
+		UnhealthyThreshold: 3,
+	}
 }
 
 //TODO put this in a config file
-var backends = []Backend{
+var backends = []lbapi.Backend{
 	{
 		Host:  "tos-spw01.nird.sigma2.no",
 		Addrs: []string{"193.156.11.24", "2001:700:4a00:11::1024"},
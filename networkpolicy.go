@@ -0,0 +1,216 @@
+package main
+
+import (
+	"bytes"
+	"log"
+	"net"
+	"strings"
+	"text/template"
+
+	"github.com/pkg/errors"
+	"k8s.io/api/core/v1"
+	netv1 "k8s.io/api/networking/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/util/intstr"
+	"k8s.io/apimachinery/pkg/util/validation"
+)
+
+//reasonInvalidNetpol is the reason of the events about the NetworkPolicy annotations sync refuses
+const reasonInvalidNetpol = "InvalidNetworkPolicy"
+
+//defaultNetpolName is the default template of the NetworkPolicy names
+const defaultNetpolName = "{{.Name}}-lb"
+
+//netpolNameTemplate is the parsed template of the NetworkPolicy names, see the netpol-name flag
+var netpolNameTemplate = template.Must(template.New("netpol-name").Parse(defaultNetpolName))
+
+//networkPolicies returns the NetworkPolicies to attach to the service,
+//either none or the one admitting the load balancers.
+func networkPolicies(ksvc v1.Service, proto v1.Protocol, ports []int32) ([]netv1.NetworkPolicy, error) {
+	enabled, err := networkPolicyEnabled(ksvc)
+	if err != nil || !enabled {
+		return nil, err
+	}
+	netPolPeers, err := networkPolicyPeers(ksvc)
+	if err != nil {
+		return nil, err
+	}
+	log.Println("generate NetworkPolicy")
+	netpol, err := newNetworkPolicy(ksvc, proto, ports, netPolPeers)
+	if err != nil {
+		return nil, err
+	}
+	return []netv1.NetworkPolicy{netpol}, nil
+}
+
+//networkPolicyEnabled tells if a NetworkPolicy should be generated for the service,
+//the annotation of the service overrides the default of the cluster.
+func networkPolicyEnabled(ksvc v1.Service) (bool, error) {
+	enabled, set, err := annotationBool(ksvc, annotationNetworkPolicy)
+	if err != nil {
+		return false, err
+	}
+	if !set {
+		enabled = *netpolEnabled
+	}
+	if enabled && len(ksvc.Spec.Selector) == 0 && *netpolSkipAll {
+		log.Printf("WARNING: service %s/%s has no selector, not generating a NetworkPolicy for all the pods in the namespace\n", ksvc.Namespace, ksvc.Name)
+		return false, nil
+	}
+	return enabled, nil
+}
+
+//networkPolicyName returns the name of the NetworkPolicy of the service,
+//from its annotation or the netpol-name template.
+func networkPolicyName(ksvc v1.Service) (string, error) {
+	if name, ok := ksvc.Annotations[annotationNetworkPolicyName]; ok {
+		name = strings.TrimSpace(name)
+		if errs := validation.IsDNS1123Subdomain(name); len(errs) > 0 {
+			return "", errors.Errorf("annotation %s: invalid name %q: %s", annotationNetworkPolicyName, name, strings.Join(errs, ", "))
+		}
+		return name, nil
+	}
+	var buf bytes.Buffer
+	if err := netpolNameTemplate.Execute(&buf, ksvc); err != nil {
+		return "", errors.Wrap(err, "error executing the NetworkPolicy name template")
+	}
+	name := strings.TrimSpace(buf.String())
+	if name == "" {
+		return "", errors.Errorf("empty NetworkPolicy name for service %s/%s", ksvc.Namespace, ksvc.Name)
+	}
+	return name, nil
+}
+
+func newNetworkPolicy(ksvc v1.Service, proto v1.Protocol, ports []int32, netPolPeers []netv1.NetworkPolicyPeer) (netv1.NetworkPolicy, error) {
+	name, err := networkPolicyName(ksvc)
+	if err != nil {
+		return netv1.NetworkPolicy{}, err
+	}
+
+	extra, err := annotationLabels(ksvc, annotationNetworkPolicyLabels)
+	if err != nil {
+		return netv1.NetworkPolicy{}, err
+	}
+	var labels map[string]string
+	if len(*netpolLabels) > 0 || len(extra) > 0 {
+		labels = make(map[string]string, len(*netpolLabels)+len(extra))
+		for k, v := range *netpolLabels {
+			labels[k] = v
+		}
+		for k, v := range extra {
+			labels[k] = v
+		}
+	}
+
+	netPolPorts := []netv1.NetworkPolicyPort{}
+	for _, p := range ports {
+		port := netv1.NetworkPolicyPort{
+			Protocol: &proto,
+			Port: &intstr.IntOrString{
+				Type:   intstr.Int,
+				IntVal: p,
+			},
+		}
+		netPolPorts = append(netPolPorts, port)
+	}
+
+	netpol := netv1.NetworkPolicy{
+		TypeMeta: metav1.TypeMeta{
+			APIVersion: "networking.k8s.io/v1",
+			Kind:       "NetworkPolicy",
+		},
+		ObjectMeta: metav1.ObjectMeta{
+			Name:   name,
+			Labels: labels,
+		},
+		Spec: netv1.NetworkPolicySpec{
+			PolicyTypes: []netv1.PolicyType{netv1.PolicyTypeIngress},
+			PodSelector: metav1.LabelSelector{
+				MatchLabels: ksvc.Spec.Selector,
+			},
+			Ingress: []netv1.NetworkPolicyIngressRule{
+				{
+					Ports: netPolPorts,
+					From:  netPolPeers,
+				},
+			},
+		},
+	}
+	return netpol, nil
+}
+
+//networkPolicyPeers returns the peers admitted by the NetworkPolicy of the service.
+//These are the load balancers, plus the loadBalancerSourceRanges when netpolRanges is
+//set and the load balancers preserve the client IPs, so that the NetworkPolicy matches
+//the ACL of the load balancers. A nil slice, that admits any source, is returned
+//for services preserving the client IPs without source ranges.
+func networkPolicyPeers(ksvc v1.Service) ([]netv1.NetworkPolicyPeer, error) {
+	netPolPeers := []netv1.NetworkPolicyPeer{}
+	for _, peer := range lbpeers.get() {
+		netin := netv1.NetworkPolicyPeer{
+			IPBlock: &netv1.IPBlock{
+				CIDR: peer,
+			},
+		}
+		netPolPeers = append(netPolPeers, netin)
+	}
+	if !*netpolRanges {
+		return netPolPeers, nil
+	}
+
+	preserved, set, err := annotationBool(ksvc, annotationPreserveClientIP)
+	if err != nil {
+		return nil, err
+	}
+	if !set {
+		preserved = ksvc.Spec.ExternalTrafficPolicy == v1.ServiceExternalTrafficPolicyTypeLocal
+	}
+	if !preserved {
+		return netPolPeers, nil
+	}
+	if len(ksvc.Spec.LoadBalancerSourceRanges) == 0 {
+		return nil, nil
+	}
+
+	ranges, err := parsePeers(strings.Join(ksvc.Spec.LoadBalancerSourceRanges, ","))
+	if err != nil {
+		return nil, errors.Wrap(err, "loadBalancerSourceRanges")
+	}
+	excepts, err := annotationCIDRs(ksvc, annotationSourceRangesExcept)
+	if err != nil {
+		return nil, err
+	}
+	used := map[string]bool{}
+	for _, r := range ranges {
+		block := &netv1.IPBlock{CIDR: r}
+		for _, e := range excepts {
+			if cidrContains(r, e) {
+				block.Except = append(block.Except, e)
+				used[e] = true
+			}
+		}
+		netPolPeers = append(netPolPeers, netv1.NetworkPolicyPeer{IPBlock: block})
+	}
+	for _, e := range excepts {
+		if !used[e] {
+			return nil, errors.Errorf("annotation %s: %s is not inside any of the loadBalancerSourceRanges", annotationSourceRangesExcept, e)
+		}
+	}
+	return netPolPeers, nil
+}
+
+//cidrContains tells if the network inner is a strict subset of outer,
+//both must be valid CIDRs.
+func cidrContains(outer, inner string) bool {
+	_, o, err := net.ParseCIDR(outer)
+	if err != nil {
+		return false
+	}
+	_, i, err := net.ParseCIDR(inner)
+	if err != nil {
+		return false
+	}
+	oOnes, oBits := o.Mask.Size()
+	iOnes, iBits := i.Mask.Size()
+	return oBits == iBits && iOnes > oOnes && o.Contains(i.IP)
+}
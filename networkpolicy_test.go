@@ -0,0 +1,190 @@
+package main
+
+import (
+	"reflect"
+	"testing"
+	"text/template"
+
+	"k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+func ipBlocks(t *testing.T, ksvc v1.Service) map[string][]string {
+	peers, err := networkPolicyPeers(ksvc)
+	if err != nil {
+		t.Fatalf("networkPolicyPeers() error = %v", err)
+	}
+	if peers == nil {
+		return nil
+	}
+	ret := map[string][]string{}
+	for _, p := range peers {
+		ret[p.IPBlock.CIDR] = p.IPBlock.Except
+	}
+	return ret
+}
+
+func TestNetworkPolicyPeers(t *testing.T) {
+	lbpeers.set([]string{"10.0.0.1/32", "2001:db8::1/128"})
+	defer func(v bool) { *netpolRanges = v }(*netpolRanges)
+
+	ksvc := v1.Service{
+		ObjectMeta: metav1.ObjectMeta{Name: "nginx", Namespace: "default"},
+		Spec: v1.ServiceSpec{
+			Type:                     v1.ServiceTypeLoadBalancer,
+			LoadBalancerSourceRanges: []string{"192.168.0.0/16", "2001:db8:1::/48"},
+		},
+	}
+	onlyLB := map[string][]string{"10.0.0.1/32": nil, "2001:db8::1/128": nil}
+	withRanges := map[string][]string{
+		"10.0.0.1/32":     nil,
+		"2001:db8::1/128": nil,
+		"192.168.0.0/16":  nil,
+		"2001:db8:1::/48": nil,
+	}
+
+	*netpolRanges = false
+	ksvc.Spec.ExternalTrafficPolicy = v1.ServiceExternalTrafficPolicyTypeLocal
+	if got := ipBlocks(t, ksvc); !reflect.DeepEqual(got, onlyLB) {
+		t.Errorf("source ranges disabled: got %v, want %v", got, onlyLB)
+	}
+
+	*netpolRanges = true
+	if got := ipBlocks(t, ksvc); !reflect.DeepEqual(got, withRanges) {
+		t.Errorf("externalTrafficPolicy Local: got %v, want %v", got, withRanges)
+	}
+
+	ksvc.Spec.ExternalTrafficPolicy = v1.ServiceExternalTrafficPolicyTypeCluster
+	if got := ipBlocks(t, ksvc); !reflect.DeepEqual(got, onlyLB) {
+		t.Errorf("client IP not preserved: got %v, want %v", got, onlyLB)
+	}
+
+	ksvc.Annotations = map[string]string{
+		annotationPreserveClientIP:   "true",
+		annotationSourceRangesExcept: "192.168.1.0/24,192.168.2.1",
+	}
+	withRanges["192.168.0.0/16"] = []string{"192.168.1.0/24", "192.168.2.1/32"}
+	if got := ipBlocks(t, ksvc); !reflect.DeepEqual(got, withRanges) {
+		t.Errorf("preserve-client-ip annotation with except: got %v, want %v", got, withRanges)
+	}
+
+	ksvc.Annotations[annotationSourceRangesExcept] = "172.16.0.0/24"
+	if _, err := networkPolicyPeers(ksvc); err == nil {
+		t.Errorf("networkPolicyPeers() except outside source ranges should fail")
+	}
+
+	delete(ksvc.Annotations, annotationSourceRangesExcept)
+	ksvc.Spec.LoadBalancerSourceRanges = nil
+	if got := ipBlocks(t, ksvc); got != nil {
+		t.Errorf("no source ranges should admit any source, got %v", got)
+	}
+}
+
+func TestNetworkPolicies(t *testing.T) {
+	lbpeers.set([]string{"10.0.0.1/32"})
+	defer func(enabled, skip bool, labels map[string]string) {
+		*netpolEnabled, *netpolSkipAll, *netpolLabels = enabled, skip, labels
+		netpolNameTemplate = template.Must(template.New("netpol-name").Parse(defaultNetpolName))
+	}(*netpolEnabled, *netpolSkipAll, *netpolLabels)
+
+	ksvc := v1.Service{
+		ObjectMeta: metav1.ObjectMeta{Name: "nginx", Namespace: "web"},
+		Spec: v1.ServiceSpec{
+			Type:     v1.ServiceTypeLoadBalancer,
+			Selector: map[string]string{"app": "nginx"},
+		},
+	}
+	*netpolEnabled, *netpolSkipAll = true, true
+	*netpolLabels = map[string]string{"team": "web"}
+
+	netpols, err := networkPolicies(ksvc, v1.ProtocolTCP, []int32{80})
+	if err != nil || len(netpols) != 1 {
+		t.Fatalf("networkPolicies() = %v, %v, want one NetworkPolicy", netpols, err)
+	}
+	if netpols[0].Name != "nginx-lb" {
+		t.Errorf("networkPolicies() default name = %s, want nginx-lb", netpols[0].Name)
+	}
+	if !reflect.DeepEqual(netpols[0].Labels, *netpolLabels) {
+		t.Errorf("networkPolicies() labels = %v, want %v", netpols[0].Labels, *netpolLabels)
+	}
+
+	netpolNameTemplate = template.Must(template.New("netpol-name").Parse("lb-{{.Namespace}}-{{.Name}}"))
+	netpols, _ = networkPolicies(ksvc, v1.ProtocolTCP, []int32{80})
+	if len(netpols) != 1 || netpols[0].Name != "lb-web-nginx" {
+		t.Errorf("networkPolicies() templated name = %v, want lb-web-nginx", netpols)
+	}
+
+	ksvc.Annotations = map[string]string{
+		annotationNetworkPolicyName:   "nginx-allow-lb",
+		annotationNetworkPolicyLabels: "team=frontend, tier=edge",
+	}
+	netpols, err = networkPolicies(ksvc, v1.ProtocolTCP, []int32{80})
+	if err != nil || len(netpols) != 1 || netpols[0].Name != "nginx-allow-lb" {
+		t.Errorf("networkPolicies() name by annotation = %v, %v, want nginx-allow-lb", netpols, err)
+	} else if want := map[string]string{"team": "frontend", "tier": "edge"}; !reflect.DeepEqual(netpols[0].Labels, want) {
+		t.Errorf("networkPolicies() labels by annotation = %v, want %v", netpols[0].Labels, want)
+	}
+	for _, bad := range []map[string]string{
+		{annotationNetworkPolicyName: "Nginx_LB"},
+		{annotationNetworkPolicyLabels: "team"},
+		{annotationNetworkPolicyLabels: "team=front end"},
+	} {
+		ksvc.Annotations = bad
+		if _, err := networkPolicies(ksvc, v1.ProtocolTCP, []int32{80}); err == nil {
+			t.Errorf("networkPolicies() with annotations %v should fail", bad)
+		}
+	}
+
+	ksvc.Annotations = map[string]string{annotationNetworkPolicy: "false"}
+	if netpols, _ := networkPolicies(ksvc, v1.ProtocolTCP, []int32{80}); len(netpols) != 0 {
+		t.Errorf("networkPolicies() disabled by annotation = %v, want none", netpols)
+	}
+
+	*netpolEnabled = false
+	ksvc.Annotations[annotationNetworkPolicy] = "true"
+	if netpols, _ := networkPolicies(ksvc, v1.ProtocolTCP, []int32{80}); len(netpols) != 1 {
+		t.Errorf("networkPolicies() enabled by annotation = %v, want one", netpols)
+	}
+
+	ksvc.Spec.Selector = nil
+	if netpols, _ := networkPolicies(ksvc, v1.ProtocolTCP, []int32{80}); len(netpols) != 0 {
+		t.Errorf("networkPolicies() without selector = %v, want none", netpols)
+	}
+	*netpolSkipAll = false
+	if netpols, _ := networkPolicies(ksvc, v1.ProtocolTCP, []int32{80}); len(netpols) != 1 {
+		t.Errorf("networkPolicies() without selector not skipped = %v, want one", netpols)
+	}
+
+	ksvc.Annotations[annotationNetworkPolicy] = "maybe"
+	if _, err := networkPolicies(ksvc, v1.ProtocolTCP, []int32{80}); err == nil {
+		t.Errorf("networkPolicies() invalid annotation should fail")
+	}
+}
+
+func TestSyncInvalidNetworkPolicy(t *testing.T) {
+	srv := newTestAPI(t)
+	posted := newTestEvents(t)
+
+	ksvc := newTestKService(v1.ServiceTypeLoadBalancer,
+		v1.ServicePort{Protocol: v1.ProtocolTCP, Port: 80, NodePort: 30080})
+	response, err := sync(&SyncRequest{Service: ksvc})
+	if err != nil || len(response.Attachments) != 1 {
+		t.Fatalf("sync() = %+v, %v, want a NetworkPolicy", response, err)
+	}
+
+	ksvc.Annotations = map[string]string{annotationNetworkPolicyName: "Nginx_LB"}
+	ksvc.Spec.Ports[0].NodePort = 30081
+	response, err = sync(&SyncRequest{Service: ksvc, Attachments: attachments(response)})
+	if err != nil {
+		t.Fatalf("sync() error = %v", err)
+	}
+	if len(response.Attachments) != 1 || response.Attachments[0].Name != "nginx-lb" {
+		t.Errorf("sync() attachments = %+v, want the nginx-lb NetworkPolicy kept", response.Attachments)
+	}
+	if svc, _ := srv.Service("nirddefaultnginxtcp"); svc.Config.Ports["80"] != 30080 {
+		t.Errorf("sync() updated the load balancer service despite the invalid annotation")
+	}
+	if len(*posted) != 1 || (*posted)[0].Reason != reasonInvalidNetpol || (*posted)[0].Type != v1.EventTypeWarning {
+		t.Errorf("sync() events = %+v, want a %s warning", *posted, reasonInvalidNetpol)
+	}
+}
@@ -0,0 +1,63 @@
+package main
+
+import (
+	"net"
+	"strings"
+
+	"github.com/UNINETT/lbcontroller/lbapi"
+	"github.com/pkg/errors"
+	"k8s.io/api/core/v1"
+)
+
+//labelExcludeFromLB is the well-known label of the nodes that must not
+//receive traffic from the external load balancers
+const labelExcludeFromLB = "node.kubernetes.io/exclude-from-external-load-balancers"
+
+//drainNodes sets the backends on nodes in maintenance, cordoned or excluded
+//from the external load balancers, to drain so the live connections are kept.
+//It fails if the nodes cannot be read, leaving the backends up would undrain
+//the nodes in maintenance until the next sync.
+func drainNodes(backends []lbapi.Backend) ([]lbapi.Backend, error) {
+	if kube == nil {
+		return backends, nil
+	}
+	nodes, err := kube.listNodes()
+	if err != nil {
+		return nil, errors.Wrap(err, "cannot read the nodes to drain")
+	}
+	ret := make([]lbapi.Backend, 0, len(backends))
+	for _, b := range backends {
+		if node, ok := backendNode(b, nodes); ok && b.State == "" && nodeExcluded(node) {
+			b.State = lbapi.BackendDrain
+		}
+		ret = append(ret, b)
+	}
+	return ret, nil
+}
+
+//nodeExcluded tells if the load balancers should not send new connections to node
+func nodeExcluded(node v1.Node) bool {
+	_, excluded := node.Labels[labelExcludeFromLB]
+	return node.Spec.Unschedulable || excluded
+}
+
+//backendNode returns the node of backend b, the one named as its host, or its
+//short name, or with one of its addresses.
+func backendNode(b lbapi.Backend, nodes []v1.Node) (v1.Node, bool) {
+	short := strings.SplitN(b.Host, ".", 2)[0]
+	for _, node := range nodes {
+		if node.Name == b.Host || node.Name == short {
+			return node, true
+		}
+	}
+	for _, node := range nodes {
+		for _, na := range node.Status.Addresses {
+			for _, a := range b.Addrs {
+				if ip := net.ParseIP(na.Address); ip != nil && ip.Equal(net.ParseIP(a)) {
+					return node, true
+				}
+			}
+		}
+	}
+	return v1.Node{}, false
+}
@@ -0,0 +1,119 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"testing"
+
+	"github.com/UNINETT/lbcontroller/lbapi"
+	"k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+//newTestNodes starts a fake Kubernetes API returning nodes
+func newTestNodes(t *testing.T, nodes ...v1.Node) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/api/v1/nodes" {
+			http.NotFound(w, r)
+			return
+		}
+		json.NewEncoder(w).Encode(v1.NodeList{Items: nodes})
+	}))
+	kube, _ = newKubeClient(srv.URL, "")
+	t.Cleanup(func() {
+		srv.Close()
+		kube = nil
+	})
+}
+
+func TestDrainNodes(t *testing.T) {
+	newTestNodes(t,
+		v1.Node{ObjectMeta: metav1.ObjectMeta{Name: "tos-spw01"}, Spec: v1.NodeSpec{Unschedulable: true}},
+		v1.Node{ObjectMeta: metav1.ObjectMeta{Name: "tos-spw02.nird.sigma2.no"}},
+		v1.Node{ObjectMeta: metav1.ObjectMeta{Name: "node3", Labels: map[string]string{labelExcludeFromLB: ""}},
+			Status: v1.NodeStatus{Addresses: []v1.NodeAddress{{Type: v1.NodeInternalIP, Address: "193.156.11.26"}}}},
+		v1.Node{ObjectMeta: metav1.ObjectMeta{Name: "tos-spw04"}, Spec: v1.NodeSpec{Unschedulable: true}},
+	)
+
+	got, err := drainNodes(backends[:5])
+	if err != nil {
+		t.Fatalf("drainNodes() error = %v", err)
+	}
+	states := []string{}
+	for _, b := range got {
+		states = append(states, b.State)
+	}
+	want := []string{lbapi.BackendDrain, "", lbapi.BackendDrain, lbapi.BackendDrain, ""}
+	if !reflect.DeepEqual(states, want) {
+		t.Errorf("drainNodes() states = %q, want %q", states, want)
+	}
+	if backends[0].State != "" {
+		t.Errorf("drainNodes() modified the backends")
+	}
+
+	down := []lbapi.Backend{{Host: "tos-spw01.nird.sigma2.no", Addrs: []string{"193.156.11.24"}, State: lbapi.BackendDown}}
+	if got, _ := drainNodes(down); got[0].State != lbapi.BackendDown {
+		t.Errorf("drainNodes() state = %q, want down kept", got[0].State)
+	}
+
+	kube = nil
+	if got, err := drainNodes(backends); err != nil || !reflect.DeepEqual(got, backends) {
+		t.Errorf("drainNodes() without the Kubernetes API changed the backends")
+	}
+}
+
+func TestSyncKeepsDrains(t *testing.T) {
+	srv := newTestAPI(t)
+
+	ksvc := newTestKService(v1.ServiceTypeLoadBalancer,
+		v1.ServicePort{Protocol: v1.ProtocolTCP, Port: 80, NodePort: 30080})
+	if _, err := sync(&SyncRequest{Service: ksvc}); err != nil {
+		t.Fatalf("sync() error = %v", err)
+	}
+	svc, _ := srv.Service("nirddefaultnginxtcp")
+	svc.SetBackendState("tos-spw03", lbapi.BackendDrain)
+	if err := srv.PutService(svc); err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err := sync(&SyncRequest{Service: ksvc}); err != nil {
+		t.Fatalf("sync() error = %v", err)
+	}
+	svc, _ = srv.Service("nirddefaultnginxtcp")
+	if svc.Config.Backends[2].State != lbapi.BackendDrain || svc.DrainedHosts()[0] != "tos-spw03.nird.sigma2.no" {
+		t.Errorf("sync() backends = %+v, want tos-spw03 still drained", svc.Config.Backends)
+	}
+}
+
+func TestSyncNodesUnavailable(t *testing.T) {
+	srv := newTestAPI(t)
+	newTestNodes(t, v1.Node{ObjectMeta: metav1.ObjectMeta{Name: "tos-spw01"}, Spec: v1.NodeSpec{Unschedulable: true}})
+
+	ksvc := newTestKService(v1.ServiceTypeLoadBalancer,
+		v1.ServicePort{Protocol: v1.ProtocolTCP, Port: 80, NodePort: 30080})
+	if _, err := sync(&SyncRequest{Service: ksvc}); err != nil {
+		t.Fatalf("sync() error = %v", err)
+	}
+	want, _ := srv.Service("nirddefaultnginxtcp")
+	if want.Config.Backends[0].State != lbapi.BackendDrain {
+		t.Fatalf("sync() backends = %+v, want tos-spw01 drained", want.Config.Backends)
+	}
+
+	//the nodes cannot be read, the cordoned node must not be undrained
+	broken := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		http.Error(w, "etcdserver: request timed out", http.StatusInternalServerError)
+	}))
+	defer broken.Close()
+	kube, _ = newKubeClient(broken.URL, "")
+	ksvc.Spec.Ports[0].NodePort = 30081
+	if _, err := sync(&SyncRequest{Service: ksvc}); err == nil {
+		t.Errorf("sync() should fail when the nodes cannot be read")
+	}
+	srv.AssertService(t, "nirddefaultnginxtcp", want.Config)
+
+	if _, err := wantedService(ksvc, nil); err == nil || isRefusal(err) {
+		t.Errorf("wantedService() error = %v, want a failure, not a refusal", err)
+	}
+}
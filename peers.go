@@ -0,0 +1,126 @@
+package main
+
+import (
+	"bytes"
+	"io/ioutil"
+	"log"
+	"net"
+	"strings"
+	"sync/atomic"
+	"time"
+
+	"github.com/pkg/errors"
+)
+
+//peerSet holds the load balancer peers admitted by the generated NetworkPolicies,
+//it is safe for concurrent use since the peers file can be reloaded while syncing.
+type peerSet struct {
+	cidrs atomic.Value // []string
+}
+
+//get returns the current peers, the returned slice must not be modified
+func (p *peerSet) get() []string {
+	cidrs, _ := p.cidrs.Load().([]string)
+	return cidrs
+}
+
+func (p *peerSet) set(cidrs []string) {
+	p.cidrs.Store(cidrs)
+}
+
+//parsePeer normalises a single peer to CIDR form, bare IPs become
+//a /32 for IPv4 or a /128 for IPv6.
+func parsePeer(s string) (string, error) {
+	if strings.Contains(s, "/") {
+		_, ipnet, err := net.ParseCIDR(s)
+		if err != nil {
+			return "", errors.Errorf("invalid peer CIDR %q", s)
+		}
+		return ipnet.String(), nil
+	}
+	ip := net.ParseIP(s)
+	if ip == nil {
+		return "", errors.Errorf("invalid peer IP address %q", s)
+	}
+	if ip4 := ip.To4(); ip4 != nil {
+		return ip4.String() + "/32", nil
+	}
+	return ip.String() + "/128", nil
+}
+
+//parsePeers parses a list of peers separated by commas, spaces or new lines.
+//Everything following a # up to the end of the line is a comment.
+//Duplicates are removed, the order is preserved.
+func parsePeers(s string) ([]string, error) {
+	var (
+		ret  = []string{}
+		seen = map[string]bool{}
+	)
+	for _, line := range strings.Split(s, "\n") {
+		if i := strings.Index(line, "#"); i >= 0 {
+			line = line[:i]
+		}
+		fields := strings.FieldsFunc(line, func(r rune) bool {
+			return r == ',' || r == ' ' || r == '\t' || r == '\r'
+		})
+		for _, f := range fields {
+			cidr, err := parsePeer(f)
+			if err != nil {
+				return nil, err
+			}
+			if seen[cidr] {
+				continue
+			}
+			seen[cidr] = true
+			ret = append(ret, cidr)
+		}
+	}
+	return ret, nil
+}
+
+//loadPeers returns the peers specified on the command line
+//together with the ones in the peers file, if any.
+func loadPeers(peers, file string) ([]string, error) {
+	all := peers
+	if file != "" {
+		data, err := ioutil.ReadFile(file)
+		if err != nil {
+			return nil, errors.Wrapf(err, "error reading peers file %s", file)
+		}
+		all = all + "\n" + string(data)
+	}
+	ret, err := parsePeers(all)
+	if err != nil {
+		return nil, err
+	}
+	if len(ret) == 0 {
+		return nil, errors.New("no load balancer peers specified")
+	}
+	return ret, nil
+}
+
+//watchPeersFile reloads the peers when the content of the peers file changes.
+//A ConfigMap mounted as a volume is updated in place by the kubelet,
+//so changes to it are picked up without restarting the controller.
+//An invalid file is logged and the previous peers are kept.
+func watchPeersFile(peers, file string, interval time.Duration) {
+	last, _ := ioutil.ReadFile(file)
+	for range time.Tick(interval) {
+		data, err := ioutil.ReadFile(file)
+		if err != nil {
+			log.Printf("ERROR: cannot read peers file %s: %v\n", file, err)
+			continue
+		}
+		if bytes.Equal(data, last) {
+			continue
+		}
+		last = data
+		cidrs, err := loadPeers(peers, file)
+		if err != nil {
+			log.Printf("ERROR: keeping previous load balancer peers: %v\n", err)
+			continue
+		}
+		lbpeers.set(cidrs)
+		log.Printf("reloaded load balancer peers: %v\n", cidrs)
+	}
+}
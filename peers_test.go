@@ -0,0 +1,69 @@
+package main
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+)
+
+func TestParsePeers(t *testing.T) {
+	tests := []struct {
+		name    string
+		in      string
+		want    []string
+		wantErr bool
+	}{
+		{"bare IPv4", "127.0.0.1", []string{"127.0.0.1/32"}, false},
+		{"bare IPv6", "2001:700:4a00:11::1024", []string{"2001:700:4a00:11::1024/128"}, false},
+		{"CIDRs", "10.0.0.0/8,2001:700::/32", []string{"10.0.0.0/8", "2001:700::/32"}, false},
+		{"host bits are masked", "10.1.2.3/24", []string{"10.1.2.0/24"}, false},
+		{"spaces and duplicates", " 10.0.0.1 , 10.0.0.1/32,,10.0.0.2", []string{"10.0.0.1/32", "10.0.0.2/32"}, false},
+		{"lines and comments", "# lb peers\n10.0.0.1 # tos-lb01\n\n::1\n", []string{"10.0.0.1/32", "::1/128"}, false},
+		{"empty", "", []string{}, false},
+		{"invalid IP", "10.0.0.300", nil, true},
+		{"invalid CIDR", "10.0.0.0/33", nil, true},
+		{"hostname", "lb.example.com", nil, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := parsePeers(tt.in)
+			if (err != nil) != tt.wantErr {
+				t.Errorf("parsePeers() error = %v, wantErr %v", err, tt.wantErr)
+				return
+			}
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("parsePeers() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestLoadPeers(t *testing.T) {
+	dir, err := ioutil.TempDir("", "peers")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	file := filepath.Join(dir, "peers")
+	if err := ioutil.WriteFile(file, []byte("10.0.0.2\n2001:db8::2\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	got, err := loadPeers("10.0.0.1", file)
+	if err != nil {
+		t.Fatalf("loadPeers() error = %v", err)
+	}
+	want := []string{"10.0.0.1/32", "10.0.0.2/32", "2001:db8::2/128"}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("loadPeers() = %v, want %v", got, want)
+	}
+
+	if _, err := loadPeers("", ""); err == nil {
+		t.Errorf("loadPeers() without peers should fail")
+	}
+	if _, err := loadPeers("", filepath.Join(dir, "missing")); err == nil {
+		t.Errorf("loadPeers() with a missing file should fail")
+	}
+}
@@ -0,0 +1,220 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"regexp"
+	"sort"
+
+	"github.com/UNINETT/lbcontroller/lbapi"
+	"github.com/ghodss/yaml"
+	"github.com/pkg/errors"
+	"k8s.io/api/core/v1"
+)
+
+//Actions of a plan
+const (
+	planCreate    = "create"
+	planUpdate    = "update"
+	planDelete    = "delete"
+	planUnchanged = "unchanged"
+	planSkip      = "skip"
+)
+
+//planEntry is a change the controller would make to a load balancer service
+type planEntry struct {
+	Action  string
+	Name    string   //name of the load balancer service
+	Service string   //namespace/name of the Kubernetes Service
+	Diff    []string //changed fields of updates
+	Reason  string   //why a Service is skipped
+}
+
+//plan prints to w the changes the controller would make to the load balancers.
+//The Services are read from the manifests in files, or from the cluster if no
+//file is given. Deletions are planned only for Services read from the cluster,
+//manifests do not tell which Services are gone.
+func plan(w io.Writer, files []string) error {
+	var (
+		ksvcs []v1.Service
+		err   error
+	)
+	if len(files) > 0 {
+		ksvcs, err = readManifests(files)
+	} else {
+		var kc *kubeClient
+		kc, err = newKubeClient(*kubeServer, *kubeToken)
+		if err == nil {
+			kube = kc //for the readiness probes and the nodes
+			ksvcs, err = kc.listServices()
+		}
+	}
+	if err != nil {
+		return errors.Wrap(err, "error reading the Kubernetes Services")
+	}
+
+	var current []lbapi.Service
+	err = lbendpoints.Do(func(url string) (err error) {
+		current, err = lbapi.ListServices(url, *token)
+		return err
+	})
+	if err != nil {
+		return errors.Wrap(err, "error listing the load balancer services")
+	}
+
+	printPlan(w, planChanges(ksvcs, current, len(files) == 0))
+	return nil
+}
+
+//planChanges compares the load balancer services wanted for ksvcs with the current ones,
+//as sync and the resync would make them. The Services refused by sync are
+//skipped and their load balancer services kept. If deletes is true the services
+//of this cluster not wanted anymore are deleted, the services of the other
+//clusters, or without owner, are never deleted.
+func planChanges(ksvcs []v1.Service, current []lbapi.Service, deletes bool) []planEntry {
+	entries := []planEntry{}
+	wanted := map[string]bool{}
+	currentByName := map[string]lbapi.Service{}
+	for _, svc := range current {
+		currentByName[svc.Metadata.Name] = svc
+	}
+
+	for _, ksvc := range ksvcs {
+		if !managedService(ksvc) {
+			continue
+		}
+		id := ksvc.Namespace + "/" + ksvc.Name
+		lbService, err := wantedService(ksvc, current)
+		name := lbService.Metadata.Name
+		wanted[name] = true
+		if err != nil {
+			entries = append(entries, planEntry{Action: planSkip, Name: name, Service: id, Reason: err.Error()})
+			continue
+		}
+
+		cur, found := currentByName[name]
+		switch {
+		case !found:
+			entries = append(entries, planEntry{Action: planCreate, Name: name, Service: id})
+		case cur.CheckOwner(*cluster) != nil:
+			entries = append(entries, planEntry{Action: planSkip, Name: name, Service: id, Reason: cur.CheckOwner(*cluster).Error()})
+		default:
+			lbService.KeepDrains(cur)
+			diff := diffServices(cur, lbService)
+			action := planUpdate
+			if len(diff) == 0 {
+				action = planUnchanged
+			}
+			entries = append(entries, planEntry{Action: action, Name: name, Service: id, Diff: diff})
+		}
+	}
+
+	if deletes {
+		for _, svc := range lbapi.ClusterServices(current, *cluster) {
+			if !wanted[svc.Metadata.Name] {
+				entries = append(entries, planEntry{Action: planDelete, Name: svc.Metadata.Name})
+			}
+		}
+	}
+
+	sort.SliceStable(entries, func(i, j int) bool {
+		if entries[i].Name != entries[j].Name {
+			return entries[i].Name < entries[j].Name
+		}
+		return entries[i].Service < entries[j].Service
+	})
+	return entries
+}
+
+func printPlan(w io.Writer, entries []planEntry) {
+	counts := map[string]int{}
+	for _, e := range entries {
+		counts[e.Action]++
+		switch e.Action {
+		case planCreate:
+			fmt.Fprintf(w, "+ %s (%s) will be created\n", e.Name, e.Service)
+		case planUpdate:
+			fmt.Fprintf(w, "~ %s (%s) will be updated\n", e.Name, e.Service)
+			for _, d := range e.Diff {
+				fmt.Fprintf(w, "      %s\n", d)
+			}
+		case planDelete:
+			fmt.Fprintf(w, "- %s will be deleted\n", e.Name)
+		case planSkip:
+			fmt.Fprintf(w, "! %s will be skipped: %s\n", e.Service, e.Reason)
+		}
+	}
+	fmt.Fprintf(w, "Plan: %d to create, %d to update, %d to delete, %d unchanged, %d skipped.\n",
+		counts[planCreate], counts[planUpdate], counts[planDelete], counts[planUnchanged], counts[planSkip])
+}
+
+var yamlSeparator = regexp.MustCompile(`(?m)^---\s*$`)
+
+//readManifests returns the Services in the YAML or JSON manifests in files,
+//- is the standard input. Other kinds of objects are ignored.
+func readManifests(files []string) ([]v1.Service, error) {
+	ret := []v1.Service{}
+	for _, file := range files {
+		var (
+			data []byte
+			err  error
+		)
+		if file == "-" {
+			data, err = ioutil.ReadAll(os.Stdin)
+		} else {
+			data, err = ioutil.ReadFile(file)
+		}
+		if err != nil {
+			return nil, errors.Wrapf(err, "error reading %s", file)
+		}
+		for _, doc := range yamlSeparator.Split(string(data), -1) {
+			js, err := yaml.YAMLToJSON([]byte(doc))
+			if err != nil {
+				return nil, errors.Wrapf(err, "error decoding %s", file)
+			}
+			svcs, err := decodeServices(bytes.TrimSpace(js))
+			if err != nil {
+				return nil, errors.Wrapf(err, "error decoding %s", file)
+			}
+			ret = append(ret, svcs...)
+		}
+	}
+	return ret, nil
+}
+
+//decodeServices decodes a Service, or the Services in a List, from JSON
+func decodeServices(js []byte) ([]v1.Service, error) {
+	if len(js) == 0 || bytes.Equal(js, []byte("null")) {
+		return nil, nil
+	}
+	var obj struct {
+		Kind  string            `json:"kind"`
+		Items []json.RawMessage `json:"items"`
+	}
+	if err := json.Unmarshal(js, &obj); err != nil {
+		return nil, err
+	}
+	switch obj.Kind {
+	case "Service":
+		svc := v1.Service{}
+		if err := json.Unmarshal(js, &svc); err != nil {
+			return nil, err
+		}
+		return []v1.Service{svc}, nil
+	case "List", "ServiceList":
+		ret := []v1.Service{}
+		for _, item := range obj.Items {
+			svcs, err := decodeServices(item)
+			if err != nil {
+				return nil, err
+			}
+			ret = append(ret, svcs...)
+		}
+		return ret, nil
+	}
+	return nil, nil
+}
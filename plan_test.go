@@ -0,0 +1,196 @@
+package main
+
+import (
+	"bytes"
+	"io/ioutil"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/UNINETT/lbcontroller/lbapi"
+	"k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/util/intstr"
+)
+
+func TestPlanChanges(t *testing.T) {
+	newTestAPI(t)
+
+	unchanged := newTestKService(v1.ServiceTypeLoadBalancer,
+		v1.ServicePort{Name: "http", Port: 80, NodePort: 30080})
+	unchanged.Name = "unchanged"
+	updated := newTestKService(v1.ServiceTypeLoadBalancer,
+		v1.ServicePort{Name: "http", Port: 80, NodePort: 30081})
+	updated.Name = "updated"
+	created := newTestKService(v1.ServiceTypeLoadBalancer,
+		v1.ServicePort{Name: "http", Port: 80, NodePort: 30082})
+	created.Name = "created"
+	mixed := newTestKService(v1.ServiceTypeLoadBalancer,
+		v1.ServicePort{Name: "dns", Protocol: v1.ProtocolUDP, Port: 53, NodePort: 30053},
+		v1.ServicePort{Name: "dns-tcp", Protocol: v1.ProtocolTCP, Port: 53, NodePort: 30054})
+	mixed.Name = "mixed"
+	nodePort := newTestKService(v1.ServiceTypeNodePort,
+		v1.ServicePort{Name: "http", Port: 80, NodePort: 30083})
+
+	oldUpdated := updated
+	oldUpdated.Spec.Ports = []v1.ServicePort{{Name: "http", Port: 80, NodePort: 30090}}
+	current := []lbapi.Service{
+		newlbcontrollerService(unchanged, serviceKey(unchanged, "tcp"), "tcp"),
+		newlbcontrollerService(oldUpdated, serviceKey(oldUpdated, "tcp"), "tcp"),
+		{Metadata: lbapi.Metadata{Name: "nirddefaultgonetcp", Labels: map[string]string{lbapi.LabelCluster: "nird"}}},
+		{Metadata: lbapi.Metadata{Name: "nirddefaultlegacytcp"}},
+		{Metadata: lbapi.Metadata{Name: "otherdefaultnginxtcp", Labels: map[string]string{lbapi.LabelCluster: "other"}}},
+		{Metadata: lbapi.Metadata{Name: "nirddefaultstolentcp", Labels: map[string]string{lbapi.LabelCluster: "nir"}}},
+	}
+	stolen := newTestKService(v1.ServiceTypeLoadBalancer,
+		v1.ServicePort{Name: "http", Port: 80, NodePort: 30084})
+	stolen.Name = "stolen"
+	ksvcs := []v1.Service{unchanged, updated, created, mixed, nodePort, stolen}
+
+	entries := planChanges(ksvcs, current, true)
+	got := map[string]string{}
+	for _, e := range entries {
+		key := e.Name
+		if key == "" {
+			key = e.Service
+		}
+		got[key] = e.Action
+	}
+	want := map[string]string{
+		"nirddefaultunchangedtcp": planUnchanged,
+		"nirddefaultupdatedtcp":   planUpdate,
+		"nirddefaultcreatedtcp":   planCreate,
+		"nirddefaultgonetcp":      planDelete,
+		"default/mixed":           planSkip,
+		"nirddefaultstolentcp":    planSkip,
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("planChanges() = %v, want %v", got, want)
+	}
+	for _, e := range entries {
+		if e.Action == planUpdate {
+			diff := []string{"config.health_check.port: 30090 -> 30081", `config.ports.80: 30090 -> 30081`}
+			if !reflect.DeepEqual(e.Diff, diff) {
+				t.Errorf("diff of %s = %q, want %q", e.Name, e.Diff, diff)
+			}
+		}
+	}
+
+	//manifests do not tell which services are gone
+	for _, e := range planChanges(ksvcs, current, false) {
+		if e.Action == planDelete {
+			t.Errorf("planChanges() without deletes deletes %s", e.Name)
+		}
+	}
+}
+
+func TestPlanRefused(t *testing.T) {
+	newTestAPI(t)
+	p, err := loadPolicy(writeTestPolicy(t, testPolicy))
+	if err != nil {
+		t.Fatal(err)
+	}
+	lbpolicy.Store(p)
+	defer lbpolicy.Store((*policy)(nil))
+
+	allowed := newTestKService(v1.ServiceTypeLoadBalancer,
+		v1.ServicePort{Name: "http", Port: 80, NodePort: 30080})
+	rejected := allowed
+	rejected.Name = "rejected"
+	rejected.Spec.Ports = []v1.ServicePort{{Name: "http", Port: 8080, NodePort: 30081}}
+	invalidIP := allowed
+	invalidIP.Name = "invalid"
+	invalidIP.Spec.LoadBalancerIP = "not-an-ip"
+	current := []lbapi.Service{
+		newlbcontrollerService(allowed, serviceKey(allowed, "tcp"), "tcp"),
+		newlbcontrollerService(allowed, serviceKey(rejected, "tcp"), "tcp"),
+		newlbcontrollerService(allowed, serviceKey(invalidIP, "tcp"), "tcp"),
+	}
+	//the health check of the readiness probe, as sync makes it
+	newTestPods(t, newTestPod(&v1.Probe{
+		Handler: v1.Handler{TCPSocket: &v1.TCPSocketAction{Port: intstr.FromInt(80)}},
+	}))
+
+	got := map[string]planEntry{}
+	for _, e := range planChanges([]v1.Service{allowed, rejected, invalidIP}, current, true) {
+		got[e.Name] = e
+	}
+	if e := got["nirddefaultrejectedtcp"]; e.Action != planSkip || !strings.Contains(e.Reason, "policy") {
+		t.Errorf("planChanges() of a rejected Service = %+v, want skipped, its service kept", e)
+	}
+	if e := got["nirddefaultinvalidtcp"]; e.Action != planSkip {
+		t.Errorf("planChanges() of an invalid IP = %+v, want skipped, its service kept", e)
+	}
+	if e := got["nirddefaultnginxtcp"]; e.Action != planUpdate || !strings.Contains(strings.Join(e.Diff, "\n"), "config.health_check.type") {
+		t.Errorf("planChanges() = %+v, want the health check of the readiness probe", e)
+	}
+	if len(got) != 3 {
+		t.Errorf("planChanges() = %+v, want no deletion", got)
+	}
+}
+
+func TestPlan(t *testing.T) {
+	srv := newTestAPI(t)
+
+	ksvc := newTestKService(v1.ServiceTypeLoadBalancer,
+		v1.ServicePort{Name: "http", Port: 80, NodePort: 30080})
+	if _, err := sync(&SyncRequest{Service: ksvc}); err != nil {
+		t.Fatalf("sync() error = %v", err)
+	}
+	srv.ResetRequests()
+
+	manifest := `apiVersion: v1
+kind: List
+items:
+- apiVersion: v1
+  kind: Service
+  metadata:
+    name: nginx
+    namespace: default
+  spec:
+    type: LoadBalancer
+    ports:
+    - name: http
+      port: 80
+      nodePort: 30081
+- apiVersion: apps/v1
+  kind: Deployment
+  metadata:
+    name: nginx
+---
+apiVersion: v1
+kind: Service
+metadata:
+  name: web
+  namespace: default
+spec:
+  type: LoadBalancer
+  ports:
+  - port: 443
+    nodePort: 30443
+`
+	file := filepath.Join(t.TempDir(), "services.yaml")
+	if err := ioutil.WriteFile(file, []byte(manifest), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	out := &bytes.Buffer{}
+	if err := plan(out, []string{file}); err != nil {
+		t.Fatalf("plan() error = %v", err)
+	}
+	for _, line := range []string{
+		"~ nirddefaultnginxtcp (default/nginx) will be updated",
+		"config.ports.80: 30080 -> 30081",
+		"+ nirddefaultwebtcp (default/web) will be created",
+		"Plan: 1 to create, 1 to update, 0 to delete, 0 unchanged, 0 skipped.",
+	} {
+		if !strings.Contains(out.String(), line) {
+			t.Errorf("plan() output misses %q:\n%s", line, out)
+		}
+	}
+	for _, r := range srv.Requests() {
+		if r.Method != "GET" {
+			t.Errorf("plan() sent %s %s, want only reads", r.Method, r.Path)
+		}
+	}
+}
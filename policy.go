@@ -0,0 +1,262 @@
+package main
+
+import (
+	"bytes"
+	"io/ioutil"
+	"log"
+	"strconv"
+	"strings"
+	"sync/atomic"
+	"time"
+
+	"github.com/UNINETT/lbcontroller/lbapi"
+	"github.com/ghodss/yaml"
+	"github.com/pkg/errors"
+	"k8s.io/api/core/v1"
+)
+
+//policy restricts the LoadBalancer Services of the namespaces, e.g.:
+//
+//	default:
+//	  protocols: [tcp]
+//	  ports: ["80", "443"]
+//	  maxServices: 5
+//	namespaces:
+//	  dns:
+//	    protocols: [tcp, udp]
+//	    ports: ["53"]
+//	  web:
+//	    ports: ["80", "443", "8000-8999"]
+//	    requireSourceRanges: true
+//	    allowedSourceRanges: ["10.0.0.0/8"]
+//
+//The policy of a namespace replaces the default one, namespaces without
+//policy and without default are not restricted.
+type policy struct {
+	Default    *namespacePolicy           `json:"default,omitempty"`
+	Namespaces map[string]namespacePolicy `json:"namespaces,omitempty"`
+}
+
+//namespacePolicy are the restrictions of the LoadBalancer Services of a namespace,
+//the empty value allows everything.
+type namespacePolicy struct {
+	//Protocols allowed, tcp or udp
+	Protocols []string `json:"protocols,omitempty"`
+	//Ports allowed, single ports or ranges as 8000-8999
+	Ports []string `json:"ports,omitempty"`
+	//MaxServices is the maximum number of load balancer services, 0 for no limit
+	MaxServices int `json:"maxServices,omitempty"`
+	//RequireSourceRanges rejects the services open to the world, without loadBalancerSourceRanges
+	RequireSourceRanges bool `json:"requireSourceRanges,omitempty"`
+	//AllowedSourceRanges are the CIDRs the loadBalancerSourceRanges must be inside of
+	AllowedSourceRanges []string `json:"allowedSourceRanges,omitempty"`
+}
+
+//Label and annotation set on the Services checked against the policy
+const (
+	//labelPolicy is allowed or rejected
+	labelPolicy = annotationPrefix + "policy"
+	//annotationPolicyViolation is why the Service was rejected, empty if allowed
+	annotationPolicyViolation = annotationPrefix + "policy-violation"
+)
+
+//lbpolicy is the policy in force, nil if there is none
+var lbpolicy atomic.Value // *policy
+
+func currentPolicy() *policy {
+	p, _ := lbpolicy.Load().(*policy)
+	return p
+}
+
+//loadPolicy reads and validates the policy in file
+func loadPolicy(file string) (*policy, error) {
+	data, err := ioutil.ReadFile(file)
+	if err != nil {
+		return nil, errors.Wrapf(err, "error reading policy file %s", file)
+	}
+	p := &policy{}
+	if err := yaml.Unmarshal(data, p); err != nil {
+		return nil, errors.Wrapf(err, "error decoding policy file %s", file)
+	}
+	if p.Default != nil {
+		if err := p.Default.validate(); err != nil {
+			return nil, errors.Wrapf(err, "policy file %s: default", file)
+		}
+	}
+	for ns, np := range p.Namespaces {
+		if err := np.validate(); err != nil {
+			return nil, errors.Wrapf(err, "policy file %s: namespace %s", file, ns)
+		}
+	}
+	return p, nil
+}
+
+//forNamespace returns the policy of namespace, nil if not restricted
+func (p *policy) forNamespace(namespace string) *namespacePolicy {
+	if p == nil {
+		return nil
+	}
+	if np, ok := p.Namespaces[namespace]; ok {
+		return &np
+	}
+	return p.Default
+}
+
+func (np namespacePolicy) validate() error {
+	for _, proto := range np.Protocols {
+		if !strings.EqualFold(proto, string(v1.ProtocolTCP)) && !strings.EqualFold(proto, string(v1.ProtocolUDP)) {
+			return errors.Errorf("invalid protocol %q", proto)
+		}
+	}
+	for _, r := range np.Ports {
+		if _, _, err := parsePortRange(r); err != nil {
+			return err
+		}
+	}
+	for _, cidr := range np.AllowedSourceRanges {
+		if _, err := parsePeer(cidr); err != nil {
+			return err
+		}
+	}
+	if np.MaxServices < 0 {
+		return errors.Errorf("invalid maxServices %d", np.MaxServices)
+	}
+	return nil
+}
+
+//parsePortRange parses a port, or a range of ports as 8000-8999
+func parsePortRange(s string) (from, to int32, err error) {
+	parts := strings.SplitN(s, "-", 2)
+	ports := make([]int32, len(parts))
+	for i, p := range parts {
+		n, err := strconv.ParseUint(strings.TrimSpace(p), 10, 16)
+		if err != nil || n == 0 {
+			return 0, 0, errors.Errorf("invalid port range %q", s)
+		}
+		ports[i] = int32(n)
+	}
+	from, to = ports[0], ports[len(ports)-1]
+	if from > to {
+		return 0, 0, errors.Errorf("invalid port range %q", s)
+	}
+	return from, to, nil
+}
+
+//check returns why the Service ksvc, with the given protocol and ports, is not
+//allowed by the policy. services is the number of the other load balancer
+//services of the namespace, it matters only if limited.
+func (np *namespacePolicy) check(ksvc v1.Service, proto v1.Protocol, ports []int32, services int) error {
+	if np == nil {
+		return nil
+	}
+	if len(np.Protocols) > 0 && !containsFold(np.Protocols, string(proto)) {
+		return errors.Errorf("protocol %s not allowed in namespace %s, allowed: %s", proto, ksvc.Namespace, strings.Join(np.Protocols, ","))
+	}
+	if len(np.Ports) > 0 {
+		for _, port := range ports {
+			if !np.portAllowed(port) {
+				return errors.Errorf("port %d not allowed in namespace %s, allowed: %s", port, ksvc.Namespace, strings.Join(np.Ports, ","))
+			}
+		}
+	}
+	ranges := ksvc.Spec.LoadBalancerSourceRanges
+	if np.RequireSourceRanges && len(ranges) == 0 {
+		return errors.Errorf("loadBalancerSourceRanges required in namespace %s", ksvc.Namespace)
+	}
+	if len(np.AllowedSourceRanges) > 0 {
+		if len(ranges) == 0 {
+			ranges = []string{"0.0.0.0/0", "::/0"}
+		}
+		for _, r := range ranges {
+			if !np.sourceRangeAllowed(r) {
+				return errors.Errorf("source range %s not allowed in namespace %s, allowed: %s", r, ksvc.Namespace, strings.Join(np.AllowedSourceRanges, ","))
+			}
+		}
+	}
+	if np.MaxServices > 0 && services >= np.MaxServices {
+		return errors.Errorf("namespace %s already has %d load balancer services, the maximum is %d", ksvc.Namespace, services, np.MaxServices)
+	}
+	return nil
+}
+
+func (np *namespacePolicy) portAllowed(port int32) bool {
+	for _, r := range np.Ports {
+		from, to, err := parsePortRange(r)
+		if err == nil && port >= from && port <= to {
+			return true
+		}
+	}
+	return false
+}
+
+func (np *namespacePolicy) sourceRangeAllowed(r string) bool {
+	cidr, err := parsePeer(strings.TrimSpace(r))
+	if err != nil {
+		return false
+	}
+	for _, allowed := range np.AllowedSourceRanges {
+		allowed, _ = parsePeer(allowed)
+		if allowed == cidr || cidrContains(allowed, cidr) {
+			return true
+		}
+	}
+	return false
+}
+
+//countNamespaceServices returns the number of load balancer services of namespace
+//in this cluster, but the one named key.
+func countNamespaceServices(namespace, key string) (int, error) {
+	var svcs []lbapi.Service
+	err := lbendpoints.Do(func(url string) (err error) {
+		svcs, err = lbapi.ListServices(url, *token)
+		return err
+	})
+	if err != nil {
+		return 0, errors.Wrap(err, "error counting the load balancer services")
+	}
+	return namespaceServices(svcs, namespace, key), nil
+}
+
+//namespaceServices counts the services in svcs of this cluster for namespace, but key
+func namespaceServices(svcs []lbapi.Service, namespace, key string) int {
+	n := 0
+	for _, svc := range lbapi.ClusterServices(svcs, *cluster) {
+		if svc.Metadata.Labels[lbapi.LabelNamespace] == namespace && svc.Metadata.Name != key {
+			n++
+		}
+	}
+	return n
+}
+
+func containsFold(list []string, s string) bool {
+	for _, e := range list {
+		if strings.EqualFold(e, s) {
+			return true
+		}
+	}
+	return false
+}
+
+//watchPolicyFile reloads the policy when the content of the policy file changes,
+//as watchPeersFile does. An invalid file is logged and the previous policy is kept.
+func watchPolicyFile(file string, interval time.Duration) {
+	last, _ := ioutil.ReadFile(file)
+	for range time.Tick(interval) {
+		data, err := ioutil.ReadFile(file)
+		if err != nil {
+			log.Printf("ERROR: cannot read policy file %s: %v\n", file, err)
+			continue
+		}
+		if bytes.Equal(data, last) {
+			continue
+		}
+		last = data
+		p, err := loadPolicy(file)
+		if err != nil {
+			log.Printf("ERROR: keeping previous policy: %v\n", err)
+			continue
+		}
+		lbpolicy.Store(p)
+		log.Printf("reloaded policy from %s\n", file)
+	}
+}
@@ -0,0 +1,172 @@
+package main
+
+import (
+	"io/ioutil"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"k8s.io/api/core/v1"
+)
+
+const testPolicy = `
+default:
+  protocols: [tcp]
+  ports: ["80", "443"]
+namespaces:
+  dns:
+    protocols: [TCP, UDP]
+    ports: ["53"]
+    maxServices: 1
+  web:
+    ports: ["80", "8000-8999"]
+    requireSourceRanges: true
+    allowedSourceRanges: ["10.0.0.0/8"]
+  free: {}
+`
+
+func writeTestPolicy(t *testing.T, policy string) string {
+	file := filepath.Join(t.TempDir(), "policy.yaml")
+	if err := ioutil.WriteFile(file, []byte(policy), 0644); err != nil {
+		t.Fatal(err)
+	}
+	return file
+}
+
+func TestPolicyCheck(t *testing.T) {
+	p, err := loadPolicy(writeTestPolicy(t, testPolicy))
+	if err != nil {
+		t.Fatalf("loadPolicy() error = %v", err)
+	}
+
+	tests := []struct {
+		name      string
+		namespace string
+		proto     v1.Protocol
+		ports     []int32
+		ranges    []string
+		services  int
+		violation string
+	}{
+		{"default allowed", "default", v1.ProtocolTCP, []int32{80, 443}, nil, 10, ""},
+		{"default port", "default", v1.ProtocolTCP, []int32{80, 8080}, nil, 0, "port 8080 not allowed"},
+		{"default protocol", "default", v1.ProtocolUDP, []int32{80}, nil, 0, "protocol UDP not allowed"},
+		{"namespace replaces default", "dns", v1.ProtocolUDP, []int32{53}, nil, 0, ""},
+		{"max services", "dns", v1.ProtocolTCP, []int32{53}, nil, 1, "already has 1 load balancer services"},
+		{"port range", "web", v1.ProtocolTCP, []int32{8443}, []string{"10.1.0.0/16"}, 0, ""},
+		{"source ranges required", "web", v1.ProtocolTCP, []int32{80}, nil, 0, "loadBalancerSourceRanges required"},
+		{"source range outside", "web", v1.ProtocolTCP, []int32{80}, []string{"10.0.0.0/8", "192.168.0.0/16"}, 0, "source range 192.168.0.0/16 not allowed"},
+		{"unrestricted namespace", "free", v1.ProtocolUDP, []int32{1234}, nil, 100, ""},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			ksvc := newTestKService(v1.ServiceTypeLoadBalancer)
+			ksvc.Namespace = tt.namespace
+			ksvc.Spec.LoadBalancerSourceRanges = tt.ranges
+			err := p.forNamespace(tt.namespace).check(ksvc, tt.proto, tt.ports, tt.services)
+			switch {
+			case tt.violation == "" && err != nil:
+				t.Errorf("check() error = %v, want allowed", err)
+			case tt.violation != "" && (err == nil || !strings.Contains(err.Error(), tt.violation)):
+				t.Errorf("check() error = %v, want %q", err, tt.violation)
+			}
+		})
+	}
+
+	var none *policy
+	if np := none.forNamespace("default"); np.check(v1.Service{}, v1.ProtocolUDP, []int32{1}, 0) != nil {
+		t.Errorf("check() without policy should allow everything")
+	}
+
+	for _, invalid := range []string{
+		"default: {protocols: [sctp]}",
+		"namespaces: {a: {ports: ['9000-8000']}}",
+		"namespaces: {a: {ports: ['70000']}}",
+		"namespaces: {a: {allowedSourceRanges: [10.0.0.0/33]}}",
+	} {
+		if _, err := loadPolicy(writeTestPolicy(t, invalid)); err == nil {
+			t.Errorf("loadPolicy(%q) should fail", invalid)
+		}
+	}
+}
+
+func TestSyncPolicy(t *testing.T) {
+	srv := newTestAPI(t)
+	p, err := loadPolicy(writeTestPolicy(t, testPolicy))
+	if err != nil {
+		t.Fatal(err)
+	}
+	lbpolicy.Store(p)
+	defer lbpolicy.Store((*policy)(nil))
+
+	posted := newTestEvents(t)
+
+	ksvc := newTestKService(v1.ServiceTypeLoadBalancer,
+		v1.ServicePort{Protocol: v1.ProtocolTCP, Port: 8080, NodePort: 30080})
+	for i := 0; i < 2; i++ {
+		response, err := sync(&SyncRequest{Service: ksvc})
+		if err != nil {
+			t.Fatalf("sync() error = %v", err)
+		}
+		if response.Labels[labelPolicy] != "rejected" || !strings.Contains(response.Annotations[annotationPolicyViolation], "port 8080") {
+			t.Errorf("sync() response = %+v, want rejected", response)
+		}
+	}
+	srv.AssertNoService(t, "nirddefaultnginxtcp")
+	if len(*posted) != 1 || (*posted)[0].Reason != "PolicyViolation" || (*posted)[0].InvolvedObject.Name != "nginx" {
+		t.Errorf("sync() events = %+v, want one PolicyViolation", *posted)
+	}
+
+	ksvc.Spec.Ports[0].Port = 80
+	response, err := sync(&SyncRequest{Service: ksvc})
+	if err != nil {
+		t.Fatalf("sync() error = %v", err)
+	}
+	if response.Labels[labelPolicy] != "allowed" || response.Annotations[annotationPolicyViolation] != "" {
+		t.Errorf("sync() response = %+v, want allowed", response)
+	}
+	if _, found := srv.Service("nirddefaultnginxtcp"); !found {
+		t.Errorf("sync() of an allowed Service did not create it")
+	}
+
+	//rejected after its creation, the load balancer service and the NetworkPolicy are kept
+	ksvc.Spec.Ports[0].Port = 8080
+	response, err = sync(&SyncRequest{Service: ksvc, Attachments: attachments(response)})
+	if err != nil {
+		t.Fatalf("sync() error = %v", err)
+	}
+	if response.Labels[labelPolicy] != "rejected" || len(response.Attachments) != 1 || response.Attachments[0].Name != "nginx-lb" {
+		t.Errorf("sync() response = %+v, want rejected keeping the nginx-lb NetworkPolicy", response)
+	}
+	if _, found := srv.Service("nirddefaultnginxtcp"); !found {
+		t.Errorf("sync() of a rejected Service deleted its load balancer service")
+	}
+
+	//the limit counts the other services of the namespace only
+	ksvc.Namespace = "dns"
+	ksvc.Spec.Ports[0].Port = 53
+	for _, name := range []string{"a", "a", "b"} {
+		ksvc.Name = name
+		response, err = sync(&SyncRequest{Service: ksvc})
+		if err != nil {
+			t.Fatalf("sync() error = %v", err)
+		}
+	}
+	if response.Labels[labelPolicy] != "rejected" {
+		t.Errorf("sync() of a second service in dns = %+v, want rejected", response)
+	}
+	srv.AssertNoService(t, "nirddnsbtcp")
+
+	//the verdict is cleared when the policy is removed
+	lbpolicy.Store((*policy)(nil))
+	response, err = sync(&SyncRequest{Service: ksvc})
+	if err != nil {
+		t.Fatalf("sync() error = %v", err)
+	}
+	if v, ok := response.Labels[labelPolicy]; !ok || v != "" {
+		t.Errorf("sync() without policy labels = %v, want %s cleared", response.Labels, labelPolicy)
+	}
+	if v, ok := response.Annotations[annotationPolicyViolation]; !ok || v != "" {
+		t.Errorf("sync() without policy annotations = %v, want %s cleared", response.Annotations, annotationPolicyViolation)
+	}
+}
@@ -0,0 +1,138 @@
+package main
+
+import (
+	"log"
+
+	"github.com/UNINETT/lbcontroller/lbapi"
+	"github.com/pkg/errors"
+	"k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/util/intstr"
+)
+
+//Defaults of the Kubernetes API for the probe fields left empty
+const (
+	probePeriod           = 10
+	probeTimeout          = 1
+	probeSuccessThreshold = 1
+	probeFailureThreshold = 3
+)
+
+//reasonInvalidProbeCheck is the reason of the events about an invalid
+//probe-health-check annotation, which sync refuses
+const reasonInvalidProbeCheck = "InvalidProbeHealthCheck"
+
+//probeChecksEnabled tells if the health check of ksvc should be derived from
+//the readiness probes, by annotation or else by flag.
+func probeChecksEnabled(ksvc v1.Service) (bool, error) {
+	enabled, set, err := annotationBool(ksvc, annotationProbeHealthCheck)
+	if err != nil {
+		return false, err
+	}
+	if !set {
+		enabled = *probeChecks
+	}
+	return enabled, nil
+}
+
+//probeHealthCheck returns the health check of ksvc derived from the readiness
+//probe of its pods, if enabled by flag or annotation. Only the TCP and HTTP
+//probes of ports exposed by ksvc can be checked by the load balancers, on the
+//node port. The services with a HealthCheckNodePort keep the check of kube-proxy.
+func probeHealthCheck(ksvc v1.Service, protocol string) (lbapi.HealthCheck, bool) {
+	enabled, _ := probeChecksEnabled(ksvc) //invalid annotations are refused by sync before
+	if !enabled || ksvc.Spec.HealthCheckNodePort != 0 || protocol != "tcp" || len(ksvc.Spec.Selector) == 0 {
+		return lbapi.HealthCheck{}, false
+	}
+	if kube == nil {
+		log.Printf("WARNING: cannot read the readiness probes of %s/%s without the Kubernetes API\n", ksvc.Namespace, ksvc.Name)
+		return lbapi.HealthCheck{}, false
+	}
+	pods, err := kube.listPods(ksvc.Namespace, ksvc.Spec.Selector)
+	if err != nil {
+		log.Printf("WARNING: %v\n", errors.Wrapf(err, "cannot read the readiness probes of %s/%s", ksvc.Namespace, ksvc.Name))
+		return lbapi.HealthCheck{}, false
+	}
+	for _, pod := range pods {
+		for _, c := range pod.Spec.Containers {
+			if hc, ok := probeToHealthCheck(ksvc, c); ok {
+				return hc, true
+			}
+		}
+	}
+	log.Printf("no readiness probe to check for %s/%s, using the default health check\n", ksvc.Namespace, ksvc.Name)
+	return lbapi.HealthCheck{}, false
+}
+
+//probeToHealthCheck translates the readiness probe of container c, a pod of
+//ksvc, into a health check of the node port exposing the probed port.
+func probeToHealthCheck(ksvc v1.Service, c v1.Container) (lbapi.HealthCheck, bool) {
+	probe := c.ReadinessProbe
+	if probe == nil {
+		return lbapi.HealthCheck{}, false
+	}
+	hc := lbapi.HealthCheck{}
+	var port intstr.IntOrString
+	switch {
+	case probe.HTTPGet != nil:
+		hc.Type = lbapi.HealthCheckHTTP
+		if probe.HTTPGet.Scheme == v1.URISchemeHTTPS {
+			hc.Type = lbapi.HealthCheckHTTPS
+		}
+		hc.Path = probe.HTTPGet.Path
+		if hc.Path == "" {
+			hc.Path = "/"
+		}
+		port = probe.HTTPGet.Port
+	case probe.TCPSocket != nil:
+		hc.Type = lbapi.HealthCheckTCP
+		port = probe.TCPSocket.Port
+	default:
+		return lbapi.HealthCheck{}, false
+	}
+	probed, ok := containerPort(c, port)
+	if !ok {
+		return lbapi.HealthCheck{}, false
+	}
+	for _, sp := range ksvc.Spec.Ports {
+		target := sp.TargetPort
+		if target.Type == intstr.Int && target.IntVal == 0 {
+			target = intstr.FromInt(int(sp.Port))
+		}
+		if p, ok := containerPort(c, target); ok && p == probed && sp.NodePort != 0 {
+			hc.Port = sp.NodePort
+			break
+		}
+	}
+	if hc.Port == 0 {
+		return lbapi.HealthCheck{}, false
+	}
+
+	hc.Interval = orDefault(probe.PeriodSeconds, probePeriod)
+	hc.Timeout = orDefault(probe.TimeoutSeconds, probeTimeout)
+	if hc.Timeout > hc.Interval {
+		hc.Timeout = hc.Interval
+	}
+	hc.HealthyThreshold = orDefault(probe.SuccessThreshold, probeSuccessThreshold)
+	hc.UnhealthyThreshold = orDefault(probe.FailureThreshold, probeFailureThreshold)
+	return hc, true
+}
+
+//containerPort resolves port, a number or the name of a port, in container c
+func containerPort(c v1.Container, port intstr.IntOrString) (int32, bool) {
+	if port.Type == intstr.Int {
+		return port.IntVal, port.IntVal != 0
+	}
+	for _, cp := range c.Ports {
+		if cp.Name == port.StrVal {
+			return cp.ContainerPort, true
+		}
+	}
+	return 0, false
+}
+
+func orDefault(v, def int32) int {
+	if v <= 0 {
+		return int(def)
+	}
+	return int(v)
+}
@@ -0,0 +1,148 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"testing"
+
+	"github.com/UNINETT/lbcontroller/lbapi"
+	"k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/util/intstr"
+)
+
+//newTestPods starts a fake Kubernetes API returning pods to the pod lists
+//selecting app=nginx, and no nodes
+func newTestPods(t *testing.T, pods ...v1.Pod) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path == "/api/v1/nodes" {
+			json.NewEncoder(w).Encode(v1.NodeList{})
+			return
+		}
+		if r.URL.Path != "/api/v1/namespaces/default/pods" || r.URL.Query().Get("labelSelector") != "app=nginx" {
+			http.NotFound(w, r)
+			return
+		}
+		json.NewEncoder(w).Encode(v1.PodList{Items: pods})
+	}))
+	kube, _ = newKubeClient(srv.URL, "")
+	oldProbeChecks := *probeChecks
+	*probeChecks = true
+	t.Cleanup(func() {
+		srv.Close()
+		kube, *probeChecks = nil, oldProbeChecks
+	})
+}
+
+func newTestPod(probe *v1.Probe) v1.Pod {
+	return v1.Pod{Spec: v1.PodSpec{Containers: []v1.Container{{
+		Name:           "nginx",
+		Ports:          []v1.ContainerPort{{Name: "http", ContainerPort: 8080}, {Name: "admin", ContainerPort: 9000}},
+		ReadinessProbe: probe,
+	}}}}
+}
+
+func TestProbeHealthCheck(t *testing.T) {
+	ksvc := newTestKService(v1.ServiceTypeLoadBalancer,
+		v1.ServicePort{Name: "http", Port: 80, TargetPort: intstr.FromString("http"), NodePort: 30080},
+		v1.ServicePort{Name: "admin", Port: 9000, NodePort: 30900},
+	)
+	tests := []struct {
+		name  string
+		probe *v1.Probe
+		want  lbapi.HealthCheck
+		ok    bool
+	}{
+		{"no probe", nil, lbapi.HealthCheck{}, false},
+		{"http", &v1.Probe{
+			Handler:       v1.Handler{HTTPGet: &v1.HTTPGetAction{Path: "/ready", Port: intstr.FromString("http")}},
+			PeriodSeconds: 5, TimeoutSeconds: 2, FailureThreshold: 2,
+		}, lbapi.HealthCheck{Type: lbapi.HealthCheckHTTP, Port: 30080, Path: "/ready",
+			Interval: 5, Timeout: 2, HealthyThreshold: 1, UnhealthyThreshold: 2}, true},
+		{"https by number", &v1.Probe{
+			Handler: v1.Handler{HTTPGet: &v1.HTTPGetAction{Port: intstr.FromInt(8080), Scheme: v1.URISchemeHTTPS}},
+		}, lbapi.HealthCheck{Type: lbapi.HealthCheckHTTPS, Port: 30080, Path: "/",
+			Interval: 10, Timeout: 1, HealthyThreshold: 1, UnhealthyThreshold: 3}, true},
+		{"tcp", &v1.Probe{
+			Handler:       v1.Handler{TCPSocket: &v1.TCPSocketAction{Port: intstr.FromString("admin")}},
+			PeriodSeconds: 1, TimeoutSeconds: 5,
+		}, lbapi.HealthCheck{Type: lbapi.HealthCheckTCP, Port: 30900,
+			Interval: 1, Timeout: 1, HealthyThreshold: 1, UnhealthyThreshold: 3}, true},
+		{"port not exposed", &v1.Probe{
+			Handler: v1.Handler{TCPSocket: &v1.TCPSocketAction{Port: intstr.FromInt(8081)}},
+		}, lbapi.HealthCheck{}, false},
+		{"exec", &v1.Probe{
+			Handler: v1.Handler{Exec: &v1.ExecAction{Command: []string{"true"}}},
+		}, lbapi.HealthCheck{}, false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			newTestPods(t, newTestPod(tt.probe))
+			got, ok := probeHealthCheck(ksvc, "tcp")
+			if ok != tt.ok || !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("probeHealthCheck() = %+v, %v, want %+v, %v", got, ok, tt.want, tt.ok)
+			}
+		})
+	}
+
+	newTestPods(t, newTestPod(&v1.Probe{Handler: v1.Handler{TCPSocket: &v1.TCPSocketAction{Port: intstr.FromInt(9000)}}}))
+	if _, ok := probeHealthCheck(ksvc, "udp"); ok {
+		t.Errorf("probeHealthCheck() of a UDP service should not use the probes")
+	}
+	optOut := ksvc
+	optOut.Annotations = map[string]string{annotationProbeHealthCheck: "false"}
+	if _, ok := probeHealthCheck(optOut, "tcp"); ok {
+		t.Errorf("probeHealthCheck() should be disabled by annotation")
+	}
+	local := ksvc
+	local.Spec.HealthCheckNodePort = 32000
+	if _, ok := probeHealthCheck(local, "tcp"); ok {
+		t.Errorf("probeHealthCheck() should keep the check of kube-proxy")
+	}
+}
+
+func TestSyncProbeHealthCheck(t *testing.T) {
+	srv := newTestAPI(t)
+	newTestPods(t, newTestPod(&v1.Probe{
+		Handler: v1.Handler{HTTPGet: &v1.HTTPGetAction{Path: "/ready", Port: intstr.FromInt(8080)}},
+	}))
+
+	ksvc := newTestKService(v1.ServiceTypeLoadBalancer,
+		v1.ServicePort{Protocol: v1.ProtocolTCP, Port: 80, TargetPort: intstr.FromInt(8080), NodePort: 30080})
+	if _, err := sync(&SyncRequest{Service: ksvc}); err != nil {
+		t.Fatalf("sync() error = %v", err)
+	}
+	svc, _ := srv.Service("nirddefaultnginxtcp")
+	if hc := svc.Config.HealthCheck; hc.Type != lbapi.HealthCheckHTTP || hc.Port != 30080 || hc.Path != "/ready" {
+		t.Errorf("health check = %+v, want the readiness probe", hc)
+	}
+}
+
+func TestSyncInvalidProbeHealthCheck(t *testing.T) {
+	srv := newTestAPI(t)
+	posted := newTestEvents(t)
+
+	ksvc := newTestKService(v1.ServiceTypeLoadBalancer,
+		v1.ServicePort{Protocol: v1.ProtocolTCP, Port: 80, NodePort: 30080})
+	response, err := sync(&SyncRequest{Service: ksvc})
+	if err != nil || len(response.Attachments) != 1 {
+		t.Fatalf("sync() = %+v, %v, want a NetworkPolicy", response, err)
+	}
+
+	ksvc.Annotations = map[string]string{annotationProbeHealthCheck: "sometimes"}
+	ksvc.Spec.Ports[0].NodePort = 30081
+	response, err = sync(&SyncRequest{Service: ksvc, Attachments: attachments(response)})
+	if err != nil {
+		t.Fatalf("sync() error = %v", err)
+	}
+	if len(response.Attachments) != 1 {
+		t.Errorf("sync() attachments = %+v, want the NetworkPolicy kept", response.Attachments)
+	}
+	if svc, _ := srv.Service("nirddefaultnginxtcp"); svc.Config.Ports["80"] != 30080 {
+		t.Errorf("sync() updated the load balancer service despite the invalid annotation")
+	}
+	if len(*posted) != 1 || (*posted)[0].Reason != reasonInvalidProbeCheck || (*posted)[0].Type != v1.EventTypeWarning {
+		t.Errorf("sync() events = %+v, want a %s warning", *posted, reasonInvalidProbeCheck)
+	}
+}
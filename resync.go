@@ -0,0 +1,163 @@
+package main
+
+import (
+	"expvar"
+	"fmt"
+	"log"
+	"strings"
+	"time"
+
+	"github.com/UNINETT/lbcontroller/lbapi"
+	"github.com/pkg/errors"
+	"k8s.io/api/core/v1"
+)
+
+//reasonDrift is the reason of the events about the drift corrections
+const reasonDrift = "DriftCorrected"
+
+//resyncStats counts the resync runs, the Services checked, the load balancer
+//services recreated or updated and the errors, published by expvar as
+//lbcontroller_resync.
+var resyncStats = expvar.NewMap("lbcontroller_resync")
+
+//resyncLoop runs resync every interval
+func resyncLoop(interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for range ticker.C {
+		if err := resync(); err != nil {
+			log.Printf("ERROR: resync: %v\n", err)
+		}
+	}
+}
+
+//resync compares the load balancer service of every Service managed by the
+//controller with the one wanted, and applies it again if missing or changed,
+//e.g. after a restore of the API. The Services refused by sync are skipped,
+//sync records the events about them.
+func resync() error {
+	resyncStats.Add("runs", 1)
+	ksvcs, err := kube.listServices()
+	if err != nil {
+		resyncStats.Add("errors", 1)
+		return errors.Wrap(err, "error reading the Kubernetes Services")
+	}
+	var svcs []lbapi.Service
+	err = lbendpoints.Do(func(url string) (err error) {
+		svcs, err = lbapi.ListServices(url, *token)
+		return err
+	})
+	if err != nil {
+		resyncStats.Add("errors", 1)
+		return errors.Wrap(err, "error listing the load balancer services")
+	}
+	current := map[string]lbapi.Service{}
+	for _, svc := range svcs {
+		current[svc.Metadata.Name] = svc
+	}
+
+	for _, ksvc := range ksvcs {
+		if err := resyncService(ksvc, svcs, current); err != nil {
+			resyncStats.Add("errors", 1)
+			log.Printf("ERROR: resync of %s/%s: %v\n", ksvc.Namespace, ksvc.Name, err)
+		}
+	}
+	return nil
+}
+
+//refusal is the reason sync refuses a Service, rather than a failure to build
+//its load balancer service
+type refusal struct {
+	error
+}
+
+//isRefusal tells if err, returned by wantedService, is a refusal
+func isRefusal(err error) bool {
+	_, ok := errors.Cause(err).(refusal)
+	return ok
+}
+
+//wantedService returns the load balancer service wanted for ksvc, a Service
+//managed by the controller, or the reason sync refuses it, see isRefusal.
+//svcs are all the services in the API. A refused Service keeps its load
+//balancer service, so the name is returned with the error, empty if the
+//protocol is invalid.
+func wantedService(ksvc v1.Service, svcs []lbapi.Service) (lbapi.Service, error) {
+	svcPorts, svcProto, err := getPortsProto(ksvc)
+	if err != nil {
+		return lbapi.Service{}, refusal{err}
+	}
+	protoString := strings.ToLower(string(svcProto))
+	key := serviceKey(ksvc, protoString)
+	refused := lbapi.Service{Metadata: lbapi.Metadata{Name: key}}
+	if np := currentPolicy().forNamespace(ksvc.Namespace); np != nil {
+		if err := np.check(ksvc, svcProto, svcPorts, namespaceServices(svcs, ksvc.Namespace, key)); err != nil {
+			return refused, refusal{errors.Wrap(err, "rejected by the load balancer policy")}
+		}
+	}
+	if _, err := requestedIPs(ksvc); err != nil {
+		return refused, refusal{err}
+	}
+	if _, err := networkPolicies(ksvc, svcProto, svcPorts); err != nil {
+		return refused, refusal{err}
+	}
+	if _, err := probeChecksEnabled(ksvc); err != nil {
+		return refused, refusal{err}
+	}
+	lbService, err := desiredService(ksvc, key, protoString)
+	if err != nil {
+		return refused, err
+	}
+	return lbService, nil
+}
+
+//resyncService corrects the drift of the load balancer service of ksvc, svcs
+//are all the services in the API and current them by name.
+func resyncService(ksvc v1.Service, svcs []lbapi.Service, current map[string]lbapi.Service) error {
+	if !managedService(ksvc) {
+		return nil
+	}
+	lbService, err := wantedService(ksvc, svcs)
+	if isRefusal(err) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	resyncStats.Add("services", 1)
+
+	key := lbService.Metadata.Name
+	cur, found := current[key]
+	var msg string
+	if found {
+		if cur.CheckOwner(*cluster) != nil {
+			return nil
+		}
+		lbService.KeepDrains(cur)
+		diff := diffServices(cur, lbService)
+		if len(diff) == 0 {
+			return nil
+		}
+		resyncStats.Add("changed", 1)
+		msg = fmt.Sprintf("load balancer service %s changed in the API, applied again: %s", key, strings.Join(diff, "; "))
+	} else {
+		if *checkPools && checkPool(ksvc) != nil {
+			return nil
+		}
+		resyncStats.Add("missing", 1)
+		msg = fmt.Sprintf("load balancer service %s missing in the API, created again", key)
+	}
+
+	err = lbendpoints.Do(func(url string) error {
+		_, err := lbapi.SyncService(lbService, url, *token)
+		return err
+	})
+	if err != nil {
+		return errors.Wrapf(err, "error applying the load balancer service %s", key)
+	}
+	log.Println(msg)
+	//an event per correction, even if the same drift happens again
+	events.forget(ksvc, reasonDrift)
+	events.event(ksvc, v1.EventTypeNormal, reasonDrift, msg)
+	return nil
+}
@@ -0,0 +1,135 @@
+package main
+
+import (
+	"encoding/json"
+	"expvar"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/UNINETT/lbcontroller/lbapi"
+	"k8s.io/api/core/v1"
+)
+
+//newTestServices starts a fake Kubernetes API listing ksvcs, and no nodes
+func newTestServices(t *testing.T, ksvcs ...v1.Service) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path == "/api/v1/nodes" {
+			json.NewEncoder(w).Encode(v1.NodeList{})
+			return
+		}
+		if r.URL.Path != "/api/v1/services" {
+			http.NotFound(w, r)
+			return
+		}
+		json.NewEncoder(w).Encode(v1.ServiceList{Items: ksvcs})
+	}))
+	kube, _ = newKubeClient(srv.URL, "")
+	t.Cleanup(func() {
+		srv.Close()
+		kube = nil
+	})
+}
+
+func resyncStat(name string) int64 {
+	if v, ok := resyncStats.Get(name).(*expvar.Int); ok {
+		return v.Value()
+	}
+	return 0
+}
+
+func TestResync(t *testing.T) {
+	srv := newTestAPI(t)
+	ksvc := newTestKService(v1.ServiceTypeLoadBalancer,
+		v1.ServicePort{Protocol: v1.ProtocolTCP, Port: 80, NodePort: 30080})
+	other := ksvc
+	other.Name = "other"
+	newTestServices(t, ksvc, other, newTestKService(v1.ServiceTypeNodePort, v1.ServicePort{Port: 80, NodePort: 30081}))
+	posted := newTestEvents(t)
+
+	if _, err := sync(&SyncRequest{Service: ksvc}); err != nil {
+		t.Fatalf("sync() error = %v", err)
+	}
+	want, _ := srv.Service("nirddefaultnginxtcp")
+	owned := newlbcontrollerService(other, "nirddefaultothertcp", "tcp")
+	owned.Metadata.Labels[lbapi.LabelCluster] = "othercluster"
+	if err := srv.PutService(owned); err != nil {
+		t.Fatal(err)
+	}
+
+	//nothing changed
+	srv.ResetRequests()
+	if err := resync(); err != nil {
+		t.Fatalf("resync() error = %v", err)
+	}
+	for _, r := range srv.Requests() {
+		if r.Method == "PUT" {
+			t.Errorf("resync() updated %s, unchanged", r.Service)
+		}
+	}
+
+	//lost by the API
+	missing := resyncStat("missing")
+	if err := lbapi.DeleteService("nirddefaultnginxtcp", srv.URL, testToken); err != nil {
+		t.Fatal(err)
+	}
+	if err := resync(); err != nil {
+		t.Fatalf("resync() error = %v", err)
+	}
+	srv.AssertService(t, "nirddefaultnginxtcp", want.Config)
+	if resyncStat("missing") != missing+1 {
+		t.Errorf("resync() missing = %d, want %d", resyncStat("missing"), missing+1)
+	}
+
+	//changed in the API, twice
+	changed := resyncStat("changed")
+	for i := 0; i < 2; i++ {
+		edited := want
+		edited.Config.Method = lbapi.MethodRoundRobin
+		if err := srv.PutService(edited); err != nil {
+			t.Fatal(err)
+		}
+		if err := resync(); err != nil {
+			t.Fatalf("resync() error = %v", err)
+		}
+		srv.AssertService(t, "nirddefaultnginxtcp", want.Config)
+	}
+	if resyncStat("changed") != changed+2 {
+		t.Errorf("resync() changed = %d, want %d", resyncStat("changed"), changed+2)
+	}
+
+	if svc, _ := srv.Service("nirddefaultothertcp"); svc.Cluster() != "othercluster" {
+		t.Errorf("resync() took over the service of another cluster")
+	}
+	if len(*posted) != 3 {
+		t.Fatalf("resync() events = %d, want 3", len(*posted))
+	}
+	for _, ev := range *posted {
+		if ev.Reason != reasonDrift || ev.Type != v1.EventTypeNormal || ev.InvolvedObject.Name != "nginx" {
+			t.Errorf("resync() event = %s %s about %s, want %s", ev.Type, ev.Reason, ev.InvolvedObject.Name, reasonDrift)
+		}
+	}
+}
+
+func TestWantedServiceRefused(t *testing.T) {
+	newTestAPI(t)
+	ksvc := newTestKService(v1.ServiceTypeLoadBalancer,
+		v1.ServicePort{Protocol: v1.ProtocolTCP, Port: 80, NodePort: 30080})
+	if svc, err := wantedService(ksvc, nil); err != nil || svc.Config.Ports["80"] != 30080 {
+		t.Fatalf("wantedService() = %+v, %v, want the service", svc, err)
+	}
+
+	//refused by sync, so by the resync, plan and drift
+	for _, annotations := range []map[string]string{
+		{annotationNetworkPolicyName: "Nginx_LB"},
+		{annotationNetworkPolicyLabels: "team"},
+		{annotationNetworkPolicy: "maybe"},
+		{annotationProbeHealthCheck: "sometimes"},
+	} {
+		ksvc.Annotations = annotations
+		svc, err := wantedService(ksvc, nil)
+		if err == nil || svc.Metadata.Name != "nirddefaultnginxtcp" || svc.Config.Ports != nil {
+			t.Errorf("wantedService() with annotations %v = %+v, %v, want refused with the name only", annotations, svc, err)
+		}
+	}
+}
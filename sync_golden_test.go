@@ -0,0 +1,84 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"flag"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/UNINETT/lbcontroller/lbapi"
+)
+
+var update = flag.Bool("update", false, "update the golden files of the sync tests")
+
+//syncGolden is the result of a sync compared with the golden files
+type syncGolden struct {
+	Status   int             `json:"status"`
+	Response *SyncResponse   `json:"response,omitempty"`
+	Services []lbapi.Service `json:"services"`
+}
+
+//TestSyncGolden feeds the SyncRequests recorded from the metacontroller in
+//testdata/sync/*.json to syncHandler, and compares the SyncResponse and the
+//services stored in the API with the .golden files.
+//Run go test -run TestSyncGolden -update to regenerate the golden files.
+func TestSyncGolden(t *testing.T) {
+	fixtures, err := filepath.Glob(filepath.Join("testdata", "sync", "*.json"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(fixtures) == 0 {
+		t.Fatal("no fixtures found")
+	}
+
+	for _, fixture := range fixtures {
+		name := strings.TrimSuffix(filepath.Base(fixture), ".json")
+		t.Run(name, func(t *testing.T) {
+			srv := newTestAPI(t)
+
+			body, err := ioutil.ReadFile(fixture)
+			if err != nil {
+				t.Fatal(err)
+			}
+			rec := httptest.NewRecorder()
+			syncHandler(rec, httptest.NewRequest(http.MethodPost, "/sync", bytes.NewReader(body)))
+
+			got := syncGolden{Status: rec.Code, Services: srv.Services()}
+			if rec.Code == http.StatusOK {
+				got.Response = &SyncResponse{}
+				if err := json.Unmarshal(rec.Body.Bytes(), got.Response); err != nil {
+					t.Fatalf("error decoding SyncResponse: %v", err)
+				}
+			}
+			for i := range got.Services {
+				got.Services[i].Metadata.CreatedAt = time.Time{}
+				got.Services[i].Metadata.UpdatedAt = time.Time{}
+			}
+			gotJSON, err := json.MarshalIndent(got, "", "\t")
+			if err != nil {
+				t.Fatal(err)
+			}
+			gotJSON = append(gotJSON, '\n')
+
+			golden := strings.TrimSuffix(fixture, ".json") + ".golden"
+			if *update {
+				if err := ioutil.WriteFile(golden, gotJSON, 0644); err != nil {
+					t.Fatal(err)
+				}
+			}
+			want, err := ioutil.ReadFile(golden)
+			if err != nil {
+				t.Fatalf("error reading golden file, run with -update to create it: %v", err)
+			}
+			if !bytes.Equal(gotJSON, want) {
+				t.Errorf("sync result differs from %s, run with -update if this is expected\ngot:\n%s\nwant:\n%s", golden, gotJSON, want)
+			}
+		})
+	}
+}
@@ -0,0 +1,292 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"reflect"
+	"strings"
+	"testing"
+
+	"gopkg.in/alecthomas/kingpin.v2"
+
+	"github.com/UNINETT/lbcontroller/lbapi"
+	"github.com/UNINETT/lbcontroller/test/lbcontrollertest"
+	"k8s.io/api/core/v1"
+	netv1 "k8s.io/api/networking/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+const testToken = "testtoken"
+
+//TestMain sets the flags to their defaults
+func TestMain(m *testing.M) {
+	if _, err := kingpin.CommandLine.Parse([]string{"--endpoint", "http://localhost", "--token", testToken}); err != nil {
+		panic(err)
+	}
+	os.Exit(m.Run())
+}
+
+//newTestAPI starts a mock API and points the controller to it
+func newTestAPI(t *testing.T) *lbcontrollertest.Server {
+	return newTestAPIWithOptions(t, lbcontrollertest.Options{})
+}
+
+func newTestAPIWithOptions(t *testing.T, opts lbcontrollertest.Options) *lbcontrollertest.Server {
+	opts.Token = testToken
+	srv := lbcontrollertest.NewServer(opts)
+	oldEndpoint, oldToken, oldCluster := *lbendpoint, *token, *cluster
+	*lbendpoint, *token, *cluster = srv.URL, testToken, defaultCluster
+	if err := loadEndpoints(); err != nil {
+		t.Fatal(err)
+	}
+	lbpeers.set([]string{"10.0.0.1/32"})
+	t.Cleanup(func() {
+		srv.Close()
+		*lbendpoint, *token, *cluster = oldEndpoint, oldToken, oldCluster
+	})
+	return srv
+}
+
+//newTestEvents starts a fake Kubernetes API collecting the events recorded
+func newTestEvents(t *testing.T) *[]v1.Event {
+	posted := []v1.Event{}
+	kube := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		ev := v1.Event{}
+		json.NewDecoder(r.Body).Decode(&ev)
+		posted = append(posted, ev)
+		w.WriteHeader(http.StatusCreated)
+	}))
+	events.kube, _ = newKubeClient(kube.URL, "")
+	oldLast := events.last
+	events.last = nil
+	t.Cleanup(func() {
+		kube.Close()
+		events.kube, events.last = nil, oldLast
+	})
+	return &posted
+}
+
+func newTestKService(svcType v1.ServiceType, ports ...v1.ServicePort) v1.Service {
+	return v1.Service{
+		ObjectMeta: metav1.ObjectMeta{Name: "nginx", Namespace: "default"},
+		Spec: v1.ServiceSpec{
+			Type:     svcType,
+			Ports:    ports,
+			Selector: map[string]string{"app": "nginx"},
+		},
+	}
+}
+
+func TestSyncLoadBalancer(t *testing.T) {
+	srv := newTestAPI(t)
+
+	ksvc := newTestKService(v1.ServiceTypeLoadBalancer,
+		v1.ServicePort{Name: "http", Protocol: v1.ProtocolTCP, Port: 80, NodePort: 30080},
+		v1.ServicePort{Name: "https", Port: 443, NodePort: 30443},
+	)
+	ksvc.Spec.LoadBalancerSourceRanges = []string{"10.10.20.0/24"}
+
+	response, err := sync(&SyncRequest{Service: ksvc})
+	if err != nil {
+		t.Fatalf("sync() error = %v", err)
+	}
+
+	srv.AssertService(t, "nirddefaultnginxtcp", lbapi.Config{
+		Method:           "least_conn",
+		Ports:            map[string]int32{"80": 30080, "443": 30443},
+		Backends:         familyBackends(backends, []string{lbapi.IPv4}),
+		UpstreamMaxConns: 100,
+		ACL:              []string{"10.10.20.0/24"},
+		HealthCheck:      lbapi.HealthCheck{Port: 30080},
+		IPFamilies:       []string{lbapi.IPv4},
+	})
+	if len(response.Attachments) != 1 || response.Attachments[0].Name != "nginx-lb" {
+		t.Errorf("sync() attachments = %+v, want the nginx-lb NetworkPolicy", response.Attachments)
+	}
+	if response.Annotations["nirddefaultnginxtcp.lb.example.com"] != "127.0.0.1" {
+		t.Errorf("sync() annotations = %v, want the ingress", response.Annotations)
+	}
+	if response.Status == nil || len(response.Status.LoadBalancer.Ingress) != 1 ||
+		response.Status.LoadBalancer.Ingress[0].IP != "127.0.0.1" {
+		t.Errorf("sync() status = %+v, want the ingress", response.Status)
+	}
+}
+
+//TestNewlbcontrollerServicePorts pins the fixes of the baseline: the config was
+//never set on the service, the lower case protocol of the load balancers never
+//matched the upper case one of Kubernetes and ports without protocol were dropped.
+func TestNewlbcontrollerServicePorts(t *testing.T) {
+	ksvc := newTestKService(v1.ServiceTypeLoadBalancer,
+		v1.ServicePort{Name: "http", Protocol: v1.ProtocolTCP, Port: 80, NodePort: 30080},
+		v1.ServicePort{Name: "https", Port: 443, NodePort: 30443},
+		v1.ServicePort{Name: "dns", Protocol: v1.ProtocolUDP, Port: 53, NodePort: 30053},
+	)
+	svc := newlbcontrollerService(ksvc, "nirddefaultnginxtcp", "tcp")
+	if want := map[string]int32{"80": 30080, "443": 30443}; !reflect.DeepEqual(svc.Config.Ports, want) {
+		t.Errorf("newlbcontrollerService() ports = %v, want %v", svc.Config.Ports, want)
+	}
+	if svc.Config.Method != lbapi.MethodLeastConn || len(svc.Config.Backends) == 0 {
+		t.Errorf("newlbcontrollerService() config = %+v, want the default method and backends", svc.Config)
+	}
+	udp := newlbcontrollerService(ksvc, "nirddefaultnginxudp", "udp")
+	if want := map[string]int32{"53": 30053}; !reflect.DeepEqual(udp.Config.Ports, want) {
+		t.Errorf("newlbcontrollerService() UDP ports = %v, want %v", udp.Config.Ports, want)
+	}
+}
+
+func TestSyncSessionAffinity(t *testing.T) {
+	srv := newTestAPI(t)
+
+	ksvc := newTestKService(v1.ServiceTypeLoadBalancer,
+		v1.ServicePort{Protocol: v1.ProtocolTCP, Port: 80, NodePort: 30080})
+	ksvc.Spec.SessionAffinity = v1.ServiceAffinityClientIP
+	if _, err := sync(&SyncRequest{Service: ksvc}); err != nil {
+		t.Fatalf("sync() error = %v", err)
+	}
+	svc, _ := srv.Service("nirddefaultnginxtcp")
+	if svc.Config.Method != lbapi.MethodSourceHash || svc.Config.AffinityTimeout != 10800 {
+		t.Errorf("method = %s, affinity timeout = %d, want source_hash with the default timeout", svc.Config.Method, svc.Config.AffinityTimeout)
+	}
+
+	timeout := int32(600)
+	ksvc.Spec.SessionAffinityConfig = &v1.SessionAffinityConfig{ClientIP: &v1.ClientIPConfig{TimeoutSeconds: &timeout}}
+	if _, err := sync(&SyncRequest{Service: ksvc}); err != nil {
+		t.Fatalf("sync() error = %v", err)
+	}
+	if svc, _ = srv.Service("nirddefaultnginxtcp"); svc.Config.AffinityTimeout != 600 {
+		t.Errorf("affinity timeout = %d, want 600", svc.Config.AffinityTimeout)
+	}
+
+	ksvc.Spec.SessionAffinity = v1.ServiceAffinityNone
+	if _, err := sync(&SyncRequest{Service: ksvc}); err != nil {
+		t.Fatalf("sync() error = %v", err)
+	}
+	if svc, _ = srv.Service("nirddefaultnginxtcp"); svc.Config.Method != lbapi.MethodLeastConn || svc.Config.AffinityTimeout != 0 {
+		t.Errorf("method = %s, affinity timeout = %d, want least_conn", svc.Config.Method, svc.Config.AffinityTimeout)
+	}
+}
+
+func TestSyncSkipped(t *testing.T) {
+	srv := newTestAPI(t)
+
+	tests := []struct {
+		name string
+		ksvc v1.Service
+	}{
+		{"NodePort", newTestKService(v1.ServiceTypeNodePort,
+			v1.ServicePort{Protocol: v1.ProtocolTCP, Port: 80, NodePort: 30080})},
+		{"mixed protocols", newTestKService(v1.ServiceTypeLoadBalancer,
+			v1.ServicePort{Name: "dns", Protocol: v1.ProtocolUDP, Port: 53, NodePort: 30053},
+			v1.ServicePort{Name: "dns-tcp", Protocol: v1.ProtocolTCP, Port: 53, NodePort: 30054})},
+		{"other class", withClass(newTestKService(v1.ServiceTypeLoadBalancer,
+			v1.ServicePort{Protocol: v1.ProtocolTCP, Port: 80, NodePort: 30080}), "metallb.universe.tf/metallb")},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			response, err := sync(&SyncRequest{Service: tt.ksvc})
+			if err != nil {
+				t.Fatalf("sync() error = %v", err)
+			}
+			if len(response.Attachments) != 0 {
+				t.Errorf("sync() attachments = %+v, want none", response.Attachments)
+			}
+		})
+	}
+	if reqs := srv.Requests(); len(reqs) != 0 {
+		t.Errorf("sync() sent %d requests to the API, want none", len(reqs))
+	}
+}
+
+func TestSyncAPIError(t *testing.T) {
+	srv := newTestAPI(t)
+	srv.AddFault(lbcontrollertest.Fault{Path: "/services", ErrorRate: 1})
+
+	ksvc := newTestKService(v1.ServiceTypeLoadBalancer,
+		v1.ServicePort{Protocol: v1.ProtocolTCP, Port: 80, NodePort: 30080})
+	if _, err := sync(&SyncRequest{Service: ksvc}); err == nil {
+		t.Errorf("sync() should fail when the API fails")
+	}
+}
+
+func TestSyncOtherCluster(t *testing.T) {
+	srv := newTestAPI(t)
+
+	ksvc := newTestKService(v1.ServiceTypeLoadBalancer,
+		v1.ServicePort{Protocol: v1.ProtocolTCP, Port: 80, NodePort: 30080})
+	other := newlbcontrollerService(ksvc, "nirddefaultnginxtcp", "tcp")
+	other.Metadata.Labels[lbapi.LabelCluster] = "nir"
+	other.Config.Ports = map[string]int32{"80": 31080}
+	if err := srv.PutService(other); err != nil {
+		t.Fatal(err)
+	}
+
+	posted := newTestEvents(t)
+	netpols := map[string]map[string]netv1.NetworkPolicy{"NetworkPolicy.networking.k8s.io/v1": {
+		"nginx-lb": {ObjectMeta: metav1.ObjectMeta{Name: "nginx-lb", Namespace: "default"}},
+	}}
+	response, err := sync(&SyncRequest{Service: ksvc, Attachments: netpols})
+	if err != nil {
+		t.Fatalf("sync() error = %v", err)
+	}
+	srv.AssertService(t, "nirddefaultnginxtcp", other.Config)
+	if response.Annotations["nirddefaultnginxtcp.lb.example.com"] != "" || response.Status != nil {
+		t.Errorf("sync() response = %+v for a service of another cluster, want no ingress", response)
+	}
+	if len(response.Attachments) != 1 || response.Attachments[0].Name != "nginx-lb" {
+		t.Errorf("sync() attachments = %+v, want the nginx-lb NetworkPolicy kept", response.Attachments)
+	}
+	if len(*posted) != 1 || (*posted)[0].Reason != reasonOtherCluster || !strings.Contains((*posted)[0].Message, "owned by cluster nir") {
+		t.Errorf("sync() events = %+v, want a %s warning", *posted, reasonOtherCluster)
+	}
+
+	//services without owner are adopted
+	other.Metadata.Labels = nil
+	srv.PutService(other)
+	if _, err := sync(&SyncRequest{Service: ksvc}); err != nil {
+		t.Fatalf("sync() error = %v", err)
+	}
+	if svc, _ := srv.Service("nirddefaultnginxtcp"); svc.Cluster() != defaultCluster {
+		t.Errorf("sync() left the service to cluster %q, want it adopted", svc.Cluster())
+	}
+}
+
+func withClass(ksvc v1.Service, class string) v1.Service {
+	ksvc.Spec.LoadBalancerClass = &class
+	return ksvc
+}
+
+func TestManagedService(t *testing.T) {
+	oldUnclassed := *lbunclassed
+	defer func() { *lbunclassed = oldUnclassed }()
+
+	lb := newTestKService(v1.ServiceTypeLoadBalancer, v1.ServicePort{Port: 80})
+	tests := []struct {
+		name      string
+		ksvc      v1.Service
+		unclassed bool
+		want      bool
+	}{
+		{"unclassed", lb, true, true},
+		{"unclassed disabled", lb, false, false},
+		{"our class", withClass(lb, defaultClass), false, true},
+		{"other class", withClass(lb, "metallb.universe.tf/metallb"), true, false},
+		{"NodePort", newTestKService(v1.ServiceTypeNodePort, v1.ServicePort{Port: 80}), true, false},
+	}
+	for _, tt := range tests {
+		*lbunclassed = tt.unclassed
+		if got := managedService(tt.ksvc); got != tt.want {
+			t.Errorf("%s: managedService() = %v, want %v", tt.name, got, tt.want)
+		}
+	}
+}
+
+//attachments returns the attachments of response as metacontroller sends them back
+func attachments(response *SyncResponse) map[string]map[string]netv1.NetworkPolicy {
+	ret := map[string]map[string]netv1.NetworkPolicy{"NetworkPolicy.networking.k8s.io/v1": {}}
+	for _, np := range response.Attachments {
+		ret["NetworkPolicy.networking.k8s.io/v1"][np.Name] = np
+	}
+	return ret
+}
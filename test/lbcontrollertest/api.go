@@ -0,0 +1,378 @@
+//Package lbcontrollertest is a mock of the Uninett load balancer API.
+//
+//It validates the services as the real API does, allocates an ingress IP per
+//service from pools of IPs, checks the bearer token and can inject faults in its responses.
+//The mock can run as a program, see main.go, or be embedded in the tests
+//with NewServer.
+package lbcontrollertest
+
+import (
+	"bytes"
+	"fmt"
+	"io/ioutil"
+	"log"
+	"net/http"
+	"sync"
+
+	"github.com/UNINETT/lbcontroller/ippool"
+	"github.com/UNINETT/lbcontroller/lbapi"
+	"github.com/gorilla/mux"
+	"github.com/koki/json"
+	"github.com/pkg/errors"
+)
+
+//Options of the mock API
+type Options struct {
+	//Token the clients must send, empty to disable authentication
+	Token string
+	//DataFile is the JSON file where the services are persisted, empty to keep them in memory only
+	DataFile string
+	//IngressNet is the network the ingress IPs of the services are allocated from, 127.0.0.0/24 if empty.
+	//It is ignored if Pools are given.
+	IngressNet string
+	//Pools of ingress IPs, restricted to some namespaces or classes, see lbapi.SelectPool
+	Pools []lbapi.Pool
+	//IngressDomain is the domain of the ingress hostnames of the services, lb.example.com if empty
+	IngressDomain string
+}
+
+//Request is a request received by the API
+type Request struct {
+	Method  string
+	Path    string
+	Service string //name of the service in the path, if any
+	Body    []byte
+	Status  int //status of the response
+}
+
+//API is the mock of the load balancer API
+type API struct {
+	opts    Options
+	router  *mux.Router
+	store   *store
+	faults  faultSet
+	mu      sync.Mutex
+	records []Request
+}
+
+//New returns a mock of the load balancer API
+func New(opts Options) (*API, error) {
+	if opts.IngressNet == "" {
+		opts.IngressNet = "127.0.0.0/24"
+	}
+	if opts.IngressDomain == "" {
+		opts.IngressDomain = "lb.example.com"
+	}
+	if len(opts.Pools) == 0 {
+		opts.Pools = []lbapi.Pool{{Name: "default", Ranges: []string{opts.IngressNet}}}
+	}
+	pools := []pool{}
+	for _, p := range opts.Pools {
+		ips, err := ippool.New(p.Ranges)
+		if err != nil {
+			return nil, errors.Wrapf(err, "invalid pool %s", p.Name)
+		}
+		pools = append(pools, pool{Pool: p, ips: ips})
+	}
+	a := &API{opts: opts}
+	var err error
+	a.store, err = loadStore(opts.DataFile, pools, opts.IngressDomain)
+	if err != nil {
+		return nil, err
+	}
+
+	router := mux.NewRouter()
+	router.Use(a.record, a.injectFaults)
+
+	router.HandleFunc("/services", a.authenticated(a.listServices)).Methods("GET")
+	router.HandleFunc("/services/{name}", a.authenticated(a.getService)).Methods("GET")
+	router.HandleFunc("/services/{name}", a.authenticated(a.syncService)).Methods("PUT")
+	router.HandleFunc("/services/{name}", a.authenticated(a.deleteService)).Methods("DELETE")
+
+	router.HandleFunc("/frontends", a.authenticated(a.listFrontends)).Methods("GET")
+	router.HandleFunc("/frontends/{name}", a.authenticated(a.getFrontend)).Methods("GET")
+	router.HandleFunc("/frontends/{name}", a.authenticated(a.syncFrontend)).Methods("PUT")
+
+	router.HandleFunc("/pools", a.authenticated(a.listPools)).Methods("GET")
+
+	//the controller follows the Location header without authentication
+	router.HandleFunc("/ingress/{name}", a.getIngress).Methods("GET")
+
+	router.HandleFunc("/admin/faults", a.listFaults).Methods("GET")
+	router.HandleFunc("/admin/faults", a.addFault).Methods("POST")
+	router.HandleFunc("/admin/faults", a.clearFaults).Methods("DELETE")
+	a.router = router
+
+	return a, nil
+}
+
+func (a *API) ServeHTTP(res http.ResponseWriter, req *http.Request) {
+	a.router.ServeHTTP(res, req)
+}
+
+//Service returns the service stored in the API
+func (a *API) Service(name string) (lbapi.Service, bool) {
+	return a.store.get(name)
+}
+
+//Services returns all the services stored in the API sorted by name
+func (a *API) Services() []lbapi.Service {
+	return a.store.list()
+}
+
+//PutService stores svc in the API as if it was created by a client
+func (a *API) PutService(svc lbapi.Service) error {
+	_, err := a.store.put(svc)
+	return err
+}
+
+//SetConnections sets the live connections of host reported in the service
+//name, the connections sent by the clients are ignored.
+func (a *API) SetConnections(name, host string, n int) error {
+	return a.store.setConnections(name, host, n)
+}
+
+//Frontend returns the frontend stored in the API
+func (a *API) Frontend(name string) (lbapi.Frontend, bool) {
+	return a.store.getFrontend(name)
+}
+
+//PutFrontend stores f in the API as if it was created by a client
+func (a *API) PutFrontend(f lbapi.Frontend) error {
+	_, err := a.store.putFrontend(f)
+	return err
+}
+
+//Requests returns the requests received by the API, admin requests excluded
+func (a *API) Requests() []Request {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	return append([]Request{}, a.records...)
+}
+
+//ResetRequests forgets the requests received so far
+func (a *API) ResetRequests() {
+	a.mu.Lock()
+	a.records = nil
+	a.mu.Unlock()
+}
+
+//record is the middleware recording the API requests
+func (a *API) record(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(res http.ResponseWriter, req *http.Request) {
+		if req.URL.Path == "/admin/faults" {
+			next.ServeHTTP(res, req)
+			return
+		}
+		body, _ := ioutil.ReadAll(req.Body)
+		req.Body = ioutil.NopCloser(bytes.NewReader(body))
+		sr := &statusRecorder{ResponseWriter: res, status: http.StatusOK}
+		next.ServeHTTP(sr, req)
+
+		a.mu.Lock()
+		a.records = append(a.records, Request{
+			Method:  req.Method,
+			Path:    req.URL.Path,
+			Service: mux.Vars(req)["name"],
+			Body:    body,
+			Status:  sr.status,
+		})
+		a.mu.Unlock()
+	})
+}
+
+//statusRecorder keeps the status of a response
+type statusRecorder struct {
+	http.ResponseWriter
+	status int
+}
+
+func (s *statusRecorder) WriteHeader(status int) {
+	s.status = status
+	s.ResponseWriter.WriteHeader(status)
+}
+
+//authenticated checks the bearer token before calling h
+func (a *API) authenticated(h http.HandlerFunc) http.HandlerFunc {
+	return func(res http.ResponseWriter, req *http.Request) {
+		if a.opts.Token != "" && req.Header.Get("Authorization") != "Bearer "+a.opts.Token {
+			http.Error(res, "invalid or missing token", http.StatusUnauthorized)
+			return
+		}
+		h(res, req)
+	}
+}
+
+func ingressLocation(req *http.Request, name string) string {
+	return "http://" + req.Host + "/ingress/" + name
+}
+
+func (a *API) getService(res http.ResponseWriter, req *http.Request) {
+	res.Header().Set("Content-Type", "application/json")
+	name := mux.Vars(req)["name"]
+
+	outData, present := a.store.get(name)
+	if !present {
+		res.WriteHeader(http.StatusNotFound)
+		return
+	}
+	outgoingJSON, err := json.Marshal(outData)
+	if err != nil {
+		log.Println(err)
+		http.Error(res, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	res.Header().Add("Location", ingressLocation(req, name))
+	fmt.Fprint(res, string(outgoingJSON))
+}
+
+func (a *API) listServices(res http.ResponseWriter, req *http.Request) {
+	res.Header().Set("Content-Type", "application/json")
+	for _, svc := range a.store.list() {
+		outgoingJSON, err := json.Marshal(svc)
+		if err != nil {
+			log.Println(err)
+			http.Error(res, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		fmt.Fprint(res, string(outgoingJSON))
+	}
+}
+
+func (a *API) syncService(res http.ResponseWriter, req *http.Request) {
+	res.Header().Set("Content-Type", "application/json")
+	name := mux.Vars(req)["name"]
+
+	newSvc := lbapi.Service{}
+	if err := json.NewDecoder(req.Body).Decode(&newSvc); err != nil {
+		log.Println(err)
+		http.Error(res, err.Error(), http.StatusBadRequest)
+		return
+	}
+	if name != newSvc.Metadata.Name {
+		err := errors.Errorf("Name of service inconsistent expected %s got %s", name, newSvc.Metadata.Name)
+		log.Println(err)
+		http.Error(res, err.Error(), http.StatusBadRequest)
+		return
+	}
+	if err := newSvc.Validate(); err != nil {
+		log.Printf("invalid service %s: %v\n", name, err)
+		http.Error(res, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	created, err := a.store.put(newSvc)
+	if err != nil {
+		log.Println(err)
+		status := http.StatusInternalServerError
+		if errors.Cause(err) == errNoIngress {
+			status = http.StatusServiceUnavailable
+		}
+		http.Error(res, err.Error(), status)
+		return
+	}
+	res.Header().Add("Location", ingressLocation(req, name))
+	if created {
+		res.WriteHeader(http.StatusCreated)
+	}
+}
+
+func (a *API) deleteService(res http.ResponseWriter, req *http.Request) {
+	res.Header().Set("Content-Type", "application/json")
+	name := mux.Vars(req)["name"]
+
+	if err := a.store.delete(name); err != nil {
+		log.Println(err)
+		http.Error(res, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	res.WriteHeader(http.StatusNoContent)
+}
+
+func (a *API) getIngress(res http.ResponseWriter, req *http.Request) {
+	res.Header().Set("Content-Type", "application/json")
+	name := mux.Vars(req)["name"]
+
+	svc, present := a.store.get(name)
+	if !present {
+		res.WriteHeader(http.StatusNotFound)
+		return
+	}
+	outgoingJSON, err := json.Marshal(svc.Ingress)
+	if err != nil {
+		log.Println(err)
+		http.Error(res, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	fmt.Fprint(res, string(outgoingJSON))
+}
+
+func (a *API) listFrontends(res http.ResponseWriter, req *http.Request) {
+	res.Header().Set("Content-Type", "application/json")
+	for _, f := range a.store.listFrontends() {
+		outgoingJSON, err := json.Marshal(f)
+		if err != nil {
+			log.Println(err)
+			http.Error(res, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		fmt.Fprint(res, string(outgoingJSON))
+	}
+}
+
+func (a *API) getFrontend(res http.ResponseWriter, req *http.Request) {
+	res.Header().Set("Content-Type", "application/json")
+	name := mux.Vars(req)["name"]
+
+	f, present := a.store.getFrontend(name)
+	if !present {
+		res.WriteHeader(http.StatusNotFound)
+		return
+	}
+	outgoingJSON, err := json.Marshal(f)
+	if err != nil {
+		log.Println(err)
+		http.Error(res, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	fmt.Fprint(res, string(outgoingJSON))
+}
+
+func (a *API) syncFrontend(res http.ResponseWriter, req *http.Request) {
+	res.Header().Set("Content-Type", "application/json")
+	name := mux.Vars(req)["name"]
+
+	f := lbapi.Frontend{}
+	if err := json.NewDecoder(req.Body).Decode(&f); err != nil {
+		log.Println(err)
+		http.Error(res, err.Error(), http.StatusBadRequest)
+		return
+	}
+	if name != f.Metadata.Name {
+		err := errors.Errorf("Name of frontend inconsistent expected %s got %s", name, f.Metadata.Name)
+		log.Println(err)
+		http.Error(res, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	created, err := a.store.putFrontend(f)
+	if err != nil {
+		log.Println(err)
+		http.Error(res, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	if created {
+		res.WriteHeader(http.StatusCreated)
+	}
+}
+
+func (a *API) listPools(res http.ResponseWriter, req *http.Request) {
+	res.Header().Set("Content-Type", "application/json")
+	outgoingJSON, err := json.Marshal(a.store.poolsUsage())
+	if err != nil {
+		log.Println(err)
+		http.Error(res, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	fmt.Fprint(res, string(outgoingJSON))
+}
@@ -0,0 +1,100 @@
+package lbcontrollertest
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/UNINETT/lbcontroller/lbapi"
+	"github.com/pkg/errors"
+)
+
+func TestPersistence(t *testing.T) {
+	dir, err := ioutil.TempDir("", "lbapi")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	opts := Options{DataFile: filepath.Join(dir, "services.json")}
+
+	api, err := New(opts)
+	if err != nil {
+		t.Fatal(err)
+	}
+	svc := lbapi.Service{Type: lbapi.TCP, Metadata: lbapi.Metadata{Name: "a"}}
+	if err := api.PutService(svc); err != nil {
+		t.Fatal(err)
+	}
+
+	api, err = New(opts)
+	if err != nil {
+		t.Fatal(err)
+	}
+	got, present := api.Service("a")
+	if !present {
+		t.Fatalf("service not persisted")
+	}
+	if len(got.Ingress) != 1 || got.Ingress[0].IP != "127.0.0.1" {
+		t.Errorf("persisted ingress = %v, want 127.0.0.1", got.Ingress)
+	}
+
+	if err := api.PutService(lbapi.Service{Type: lbapi.TCP, Metadata: lbapi.Metadata{Name: "b"}}); err != nil {
+		t.Fatal(err)
+	}
+	if got, _ := api.Service("b"); len(got.Ingress) != 1 || got.Ingress[0].IP != "127.0.0.2" {
+		t.Errorf("ingress of a new service = %v, want 127.0.0.2", got.Ingress)
+	}
+}
+
+func TestParseFault(t *testing.T) {
+	f, err := ParseFault("path=/ingress,service=a,latency=2s,error_rate=0.5,status=503,truncate")
+	if err != nil {
+		t.Fatalf("ParseFault() error = %v", err)
+	}
+	if f.Path != "/ingress" || f.Service != "a" || f.latency != 2*time.Second ||
+		f.ErrorRate != 0.5 || f.Status != 503 || !f.Truncate {
+		t.Errorf("ParseFault() = %+v", f)
+	}
+
+	for _, s := range []string{"latency=2", "error_rate=2", "status=404", "colour=red"} {
+		if _, err := ParseFault(s); err == nil {
+			t.Errorf("ParseFault(%q) should fail", s)
+		}
+	}
+}
+
+func TestPools(t *testing.T) {
+	api, err := New(Options{Pools: []lbapi.Pool{
+		{Name: "web", Ranges: []string{"10.0.0.10/32"}, Namespaces: []string{"web"}},
+		{Name: "default", Ranges: []string{"10.1.0.0/24"}},
+	}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	newService := func(name, namespace string) lbapi.Service {
+		return lbapi.Service{Type: lbapi.TCP, Metadata: lbapi.Metadata{Name: name, Labels: map[string]string{lbapi.LabelNamespace: namespace}}}
+	}
+
+	if err := api.PutService(newService("a", "web")); err != nil {
+		t.Fatal(err)
+	}
+	if svc, _ := api.Service("a"); svc.Ingress[0].IP != "10.0.0.10" {
+		t.Errorf("ingress of a = %v, want from the web pool", svc.Ingress)
+	}
+	if err := api.PutService(newService("b", "web")); errors.Cause(err) != errNoIngress {
+		t.Errorf("PutService() in an exhausted pool error = %v, want %v", err, errNoIngress)
+	}
+	if err := api.PutService(newService("c", "other")); err != nil {
+		t.Fatal(err)
+	}
+	if svc, _ := api.Service("c"); svc.Ingress[0].IP != "10.1.0.1" {
+		t.Errorf("ingress of c = %v, want from the default pool", svc.Ingress)
+	}
+
+	pools := api.store.poolsUsage()
+	if len(pools) != 2 || pools[0].Free() != 0 || pools[1].Free() != 253 {
+		t.Errorf("pools usage = %+v", pools)
+	}
+}
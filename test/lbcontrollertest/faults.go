@@ -0,0 +1,247 @@
+package lbcontrollertest
+
+import (
+	"bytes"
+	"fmt"
+	"log"
+	"math/rand"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/gorilla/mux"
+	"github.com/koki/json"
+	"github.com/pkg/errors"
+)
+
+//Fault is an error condition injected in the responses of the mock,
+//scoped by path prefix and service name, empty matches everything.
+type Fault struct {
+	Path         string  `json:"path,omitempty"`
+	Service      string  `json:"service,omitempty"`
+	Latency      string  `json:"latency,omitempty"`       //delay before answering, e.g. 2s
+	ErrorRate    float64 `json:"error_rate,omitempty"`    //probability of answering with a 5xx status
+	Status       int     `json:"status,omitempty"`        //status returned by ErrorRate, random 5xx if 0
+	ThrottleRate float64 `json:"throttle_rate,omitempty"` //probability of answering 429 Too Many Requests
+	RetryAfter   int     `json:"retry_after,omitempty"`   //seconds in the Retry-After header of the 429 answers
+	Truncate     bool    `json:"truncate,omitempty"`      //send only half of the body
+	NoLocation   bool    `json:"no_location,omitempty"`   //drop the Location header
+
+	latency time.Duration
+}
+
+//faultSet holds the faults currently injected
+type faultSet struct {
+	mu     sync.Mutex
+	faults []Fault
+}
+
+func (fs *faultSet) add(f Fault) {
+	fs.mu.Lock()
+	fs.faults = append(fs.faults, f)
+	fs.mu.Unlock()
+}
+
+func (fs *faultSet) list() []Fault {
+	fs.mu.Lock()
+	defer fs.mu.Unlock()
+	return append([]Fault{}, fs.faults...)
+}
+
+func (fs *faultSet) clear() {
+	fs.mu.Lock()
+	fs.faults = nil
+	fs.mu.Unlock()
+}
+
+//matching returns the faults applying to the path and service
+func (fs *faultSet) matching(path, service string) []Fault {
+	ret := []Fault{}
+	for _, f := range fs.list() {
+		if strings.HasPrefix(path, f.Path) && (f.Service == "" || f.Service == service) {
+			ret = append(ret, f)
+		}
+	}
+	return ret
+}
+
+//check validates the fault and parses its latency
+func (f *Fault) check() error {
+	if f.Latency != "" {
+		d, err := time.ParseDuration(f.Latency)
+		if err != nil {
+			return errors.Wrap(err, "latency")
+		}
+		f.latency = d
+	}
+	if f.ErrorRate < 0 || f.ErrorRate > 1 || f.ThrottleRate < 0 || f.ThrottleRate > 1 {
+		return errors.New("rates must be between 0 and 1")
+	}
+	if f.Status != 0 && (f.Status < 500 || f.Status > 599) {
+		return errors.Errorf("status %d is not a 5xx", f.Status)
+	}
+	return nil
+}
+
+//ParseFault parses a Fault in the key=value,key=value form of the command line,
+//the keys are the JSON fields of Fault.
+func ParseFault(s string) (Fault, error) {
+	f := Fault{}
+	for _, kv := range strings.Split(s, ",") {
+		parts := strings.SplitN(kv, "=", 2)
+		key, value := parts[0], ""
+		if len(parts) == 2 {
+			value = parts[1]
+		}
+		var err error
+		switch key {
+		case "path":
+			f.Path = value
+		case "service":
+			f.Service = value
+		case "latency":
+			f.Latency = value
+		case "error_rate":
+			f.ErrorRate, err = strconv.ParseFloat(value, 64)
+		case "status":
+			f.Status, err = strconv.Atoi(value)
+		case "throttle_rate":
+			f.ThrottleRate, err = strconv.ParseFloat(value, 64)
+		case "retry_after":
+			f.RetryAfter, err = strconv.Atoi(value)
+		case "truncate":
+			f.Truncate = value == "" || value == "true"
+		case "no_location":
+			f.NoLocation = value == "" || value == "true"
+		default:
+			return f, errors.Errorf("unknown key %q", key)
+		}
+		if err != nil {
+			return f, errors.Wrapf(err, "invalid %s", key)
+		}
+	}
+	return f, f.check()
+}
+
+var serverErrors = []int{
+	http.StatusInternalServerError,
+	http.StatusBadGateway,
+	http.StatusServiceUnavailable,
+	http.StatusGatewayTimeout,
+}
+
+//injectFaults is the middleware applying the matching faults to the API requests
+func (a *API) injectFaults(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(res http.ResponseWriter, req *http.Request) {
+		if strings.HasPrefix(req.URL.Path, "/admin/") {
+			next.ServeHTTP(res, req)
+			return
+		}
+		matching := a.faults.matching(req.URL.Path, mux.Vars(req)["name"])
+		if len(matching) == 0 {
+			next.ServeHTTP(res, req)
+			return
+		}
+
+		var truncate, noLocation bool
+		for _, f := range matching {
+			time.Sleep(f.latency)
+			if rand.Float64() < f.ThrottleRate {
+				if f.RetryAfter > 0 {
+					res.Header().Set("Retry-After", strconv.Itoa(f.RetryAfter))
+				}
+				http.Error(res, "injected fault: too many requests", http.StatusTooManyRequests)
+				return
+			}
+			if rand.Float64() < f.ErrorRate {
+				status := f.Status
+				if status == 0 {
+					status = serverErrors[rand.Intn(len(serverErrors))]
+				}
+				http.Error(res, "injected fault: server error", status)
+				return
+			}
+			truncate = truncate || f.Truncate
+			noLocation = noLocation || f.NoLocation
+		}
+		if !truncate && !noLocation {
+			next.ServeHTTP(res, req)
+			return
+		}
+
+		rec := &recorder{header: http.Header{}, status: http.StatusOK}
+		next.ServeHTTP(rec, req)
+		for k, v := range rec.header {
+			res.Header()[k] = v
+		}
+		if noLocation {
+			res.Header().Del("Location")
+		}
+		body := rec.body.Bytes()
+		if truncate {
+			//announce the whole body but send only half of it,
+			//the client gets an unexpected EOF
+			res.Header().Set("Content-Length", strconv.Itoa(len(body)))
+			body = body[:len(body)/2]
+		}
+		res.WriteHeader(rec.status)
+		res.Write(body)
+	})
+}
+
+//recorder buffers a response so that faults can be applied to it
+type recorder struct {
+	header http.Header
+	status int
+	body   bytes.Buffer
+}
+
+func (r *recorder) Header() http.Header         { return r.header }
+func (r *recorder) Write(b []byte) (int, error) { return r.body.Write(b) }
+func (r *recorder) WriteHeader(status int)      { r.status = status }
+
+func (a *API) listFaults(res http.ResponseWriter, req *http.Request) {
+	res.Header().Set("Content-Type", "application/json")
+	outgoingJSON, err := json.Marshal(a.faults.list())
+	if err != nil {
+		http.Error(res, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	fmt.Fprint(res, string(outgoingJSON))
+}
+
+func (a *API) addFault(res http.ResponseWriter, req *http.Request) {
+	f := Fault{}
+	if err := json.NewDecoder(req.Body).Decode(&f); err != nil {
+		http.Error(res, err.Error(), http.StatusBadRequest)
+		return
+	}
+	if err := a.AddFault(f); err != nil {
+		http.Error(res, err.Error(), http.StatusBadRequest)
+		return
+	}
+	res.WriteHeader(http.StatusCreated)
+}
+
+func (a *API) clearFaults(res http.ResponseWriter, req *http.Request) {
+	a.ClearFaults()
+	res.WriteHeader(http.StatusNoContent)
+}
+
+//AddFault injects f in the responses of the API
+func (a *API) AddFault(f Fault) error {
+	if err := f.check(); err != nil {
+		return err
+	}
+	a.faults.add(f)
+	log.Printf("injecting fault %+v\n", f)
+	return nil
+}
+
+//ClearFaults removes all the injected faults
+func (a *API) ClearFaults() {
+	a.faults.clear()
+	log.Println("cleared all faults")
+}
@@ -0,0 +1,105 @@
+package lbcontrollertest
+
+import (
+	"io/ioutil"
+	"net/http"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/UNINETT/lbcontroller/lbapi"
+)
+
+func TestFaults(t *testing.T) {
+	srv := NewServer(Options{})
+	defer srv.Close()
+	svc := lbapi.Service{Type: lbapi.TCP, Metadata: lbapi.Metadata{Name: "a"}}
+	if err := srv.PutService(svc); err != nil {
+		t.Fatal(err)
+	}
+	full, err := http.Get(srv.URL + "/services/a")
+	if err != nil {
+		t.Fatal(err)
+	}
+	fullBody, _ := ioutil.ReadAll(full.Body)
+	full.Body.Close()
+	if full.StatusCode != http.StatusOK || full.Header.Get("Location") == "" || len(fullBody) == 0 {
+		t.Fatalf("GET without faults = %s, Location %q, body %q", full.Status, full.Header.Get("Location"), fullBody)
+	}
+
+	tests := []struct {
+		name  string
+		fault Fault
+		path  string
+		check func(t *testing.T, res *http.Response, body []byte, err error, elapsed time.Duration)
+	}{
+		{"latency", Fault{Latency: "50ms"}, "/services/a", func(t *testing.T, res *http.Response, body []byte, err error, elapsed time.Duration) {
+			if elapsed < 50*time.Millisecond || res.StatusCode != http.StatusOK || string(body) != string(fullBody) {
+				t.Errorf("answered %s after %s, body %q, want 200 after 50ms", res.Status, elapsed, body)
+			}
+		}},
+		{"throttle with Retry-After", Fault{ThrottleRate: 1, RetryAfter: 7}, "/services/a", func(t *testing.T, res *http.Response, body []byte, err error, elapsed time.Duration) {
+			if res.StatusCode != http.StatusTooManyRequests || res.Header.Get("Retry-After") != "7" || !strings.Contains(string(body), "too many requests") {
+				t.Errorf("answered %s, Retry-After %q, body %q, want 429 retrying after 7", res.Status, res.Header.Get("Retry-After"), body)
+			}
+		}},
+		{"throttle without Retry-After", Fault{ThrottleRate: 1}, "/services/a", func(t *testing.T, res *http.Response, body []byte, err error, elapsed time.Duration) {
+			if res.StatusCode != http.StatusTooManyRequests || res.Header.Get("Retry-After") != "" {
+				t.Errorf("answered %s, Retry-After %q, want 429 without Retry-After", res.Status, res.Header.Get("Retry-After"))
+			}
+		}},
+		{"server error status", Fault{ErrorRate: 1, Status: http.StatusBadGateway}, "/services/a", func(t *testing.T, res *http.Response, body []byte, err error, elapsed time.Duration) {
+			if res.StatusCode != http.StatusBadGateway || !strings.Contains(string(body), "server error") {
+				t.Errorf("answered %s, body %q, want 502", res.Status, body)
+			}
+		}},
+		{"random server error", Fault{ErrorRate: 1}, "/services/a", func(t *testing.T, res *http.Response, body []byte, err error, elapsed time.Duration) {
+			if res.StatusCode < 500 || res.StatusCode > 599 {
+				t.Errorf("answered %s, want a 5xx", res.Status)
+			}
+		}},
+		{"truncate", Fault{Truncate: true}, "/services/a", func(t *testing.T, res *http.Response, body []byte, err error, elapsed time.Duration) {
+			if res.Header.Get("Content-Length") != strconv.Itoa(len(fullBody)) || err == nil || len(body) != len(fullBody)/2 {
+				t.Errorf("answered Content-Length %s, %d bytes, read error %v, want %d announced, half sent and an unexpected EOF",
+					res.Header.Get("Content-Length"), len(body), err, len(fullBody))
+			}
+		}},
+		{"no Location", Fault{NoLocation: true}, "/services/a", func(t *testing.T, res *http.Response, body []byte, err error, elapsed time.Duration) {
+			if res.StatusCode != http.StatusOK || res.Header.Get("Location") != "" || string(body) != string(fullBody) {
+				t.Errorf("answered %s, Location %q, want 200 without Location", res.Status, res.Header.Get("Location"))
+			}
+		}},
+		{"other path", Fault{Path: "/ingress", ErrorRate: 1}, "/services/a", func(t *testing.T, res *http.Response, body []byte, err error, elapsed time.Duration) {
+			if res.StatusCode != http.StatusOK {
+				t.Errorf("answered %s, the fault of another path applied", res.Status)
+			}
+		}},
+		{"other service", Fault{Service: "b", ErrorRate: 1}, "/services/a", func(t *testing.T, res *http.Response, body []byte, err error, elapsed time.Duration) {
+			if res.StatusCode != http.StatusOK {
+				t.Errorf("answered %s, the fault of another service applied", res.Status)
+			}
+		}},
+		{"admin endpoint", Fault{ErrorRate: 1}, "/admin/faults", func(t *testing.T, res *http.Response, body []byte, err error, elapsed time.Duration) {
+			if res.StatusCode != http.StatusOK || !strings.Contains(string(body), "error_rate") {
+				t.Errorf("answered %s, body %q, want the faults listed", res.Status, body)
+			}
+		}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			srv.ClearFaults()
+			if err := srv.AddFault(tt.fault); err != nil {
+				t.Fatalf("AddFault() error = %v", err)
+			}
+			start := time.Now()
+			res, err := http.Get(srv.URL + tt.path)
+			if err != nil {
+				t.Fatalf("GET %s error = %v", tt.path, err)
+			}
+			defer res.Body.Close()
+			body, err := ioutil.ReadAll(res.Body)
+			tt.check(t, res, body, err, time.Since(start))
+		})
+	}
+}
@@ -1,141 +1,66 @@
 // +build ignore
+
 package main
 
 import (
-	"fmt"
+	"io/ioutil"
 	"log"
 	"net/http"
 	"os"
-	"time"
 
-	"github.com/koki/json"
+	"gopkg.in/alecthomas/kingpin.v2"
 
-	"github.com/UNINETT/lbcontroller"
+	"github.com/UNINETT/lbcontroller/lbapi"
+	"github.com/UNINETT/lbcontroller/test/lbcontrollertest"
+	"github.com/ghodss/yaml"
 	"github.com/gorilla/handlers"
-	"github.com/gorilla/mux"
-	"github.com/pkg/errors"
 )
 
 var (
-	services = map[string]lbcontroller.Service{}
+	listen     = kingpin.Flag("listen", "Address to listen on").Default(":8080").Envar("LBAPI_LISTEN").String()
+	token      = kingpin.Flag("token", "Token the clients must send, empty to disable authentication").Envar("LBAPI_TOKEN").String()
+	dataFile   = kingpin.Flag("data", "JSON file where the services are persisted, empty to keep them in memory only").Envar("LBAPI_DATA").String()
+	ingressNet = kingpin.Flag("ingress-net", "Network the ingress IPs of the services are allocated from").Default("127.0.0.0/24").Envar("LBAPI_INGRESS_NET").String()
+	poolsFile  = kingpin.Flag("pools", "YAML or JSON file with the pools of ingress IPs, replacing the ingress network").Envar("LBAPI_POOLS").String()
+	domain     = kingpin.Flag("ingress-domain", "Domain of the ingress hostnames of the services").Default("lb.example.com").Envar("LBAPI_INGRESS_DOMAIN").String()
+	faultFlags = kingpin.Flag("fault", "Fault to inject, as comma separated key=value fields of a fault, can be repeated").PlaceHolder("path=/ingress,latency=5s").Envar("LBAPI_FAULTS").Strings()
 )
 
 func main() {
-	router := mux.NewRouter()
-
-	router.HandleFunc("/services", listServices).Methods("GET")
-	router.HandleFunc("/services/{name}", getService).Methods("GET")
-	router.HandleFunc("/services/{name}", syncService).Methods("PUT")
-	router.HandleFunc("/services/{name}", deleteService).Methods("DELETE")
+	kingpin.Parse()
 
-	router.HandleFunc("/ingress", getIngress).Methods("GET")
-
-	loggedRouter := handlers.LoggingHandler(os.Stdout, router)
-	http.ListenAndServe(":8080", loggedRouter)
-}
-
-func getService(res http.ResponseWriter, req *http.Request) {
-	res.Header().Set("Content-Type", "application/json")
-	vars := mux.Vars(req)
-	name := vars["name"]
-
-	if len(name) == 0 {
-		log.Println("need to specify a resource name")
-		http.Error(res, "need to specify a resource name", http.StatusInternalServerError)
-		return
+	var pools []lbapi.Pool
+	if *poolsFile != "" {
+		data, err := ioutil.ReadFile(*poolsFile)
+		if err == nil {
+			err = yaml.Unmarshal(data, &pools)
+		}
+		if err != nil {
+			log.Fatalf("invalid pools file %s: %v\n", *poolsFile, err)
+		}
 	}
 
-	outData, present := services[name]
-	if !present {
-		res.WriteHeader(http.StatusNotFound)
-		return
-	}
-	outgoingJSON, error := json.Marshal(outData)
-	if error != nil {
-		log.Println(error.Error())
-		http.Error(res, error.Error(), http.StatusInternalServerError)
-		return
+	api, err := lbcontrollertest.New(lbcontrollertest.Options{
+		Token:         *token,
+		DataFile:      *dataFile,
+		IngressNet:    *ingressNet,
+		Pools:         pools,
+		IngressDomain: *domain,
+	})
+	if err != nil {
+		log.Fatal(err)
 	}
-	location := "http://" + req.Host + "/ingress"
-	res.Header().Add("Location", location)
-	fmt.Fprint(res, string(outgoingJSON))
-
-}
 
-func listServices(res http.ResponseWriter, req *http.Request) {
-	res.Header().Set("Content-Type", "application/json")
-	for _, svc := range services {
-
-		outgoingJSON, error := json.Marshal(svc)
-		if error != nil {
-			log.Println(error.Error())
-			http.Error(res, error.Error(), http.StatusInternalServerError)
-			return
+	for _, f := range *faultFlags {
+		flt, err := lbcontrollertest.ParseFault(f)
+		if err == nil {
+			err = api.AddFault(flt)
+		}
+		if err != nil {
+			log.Fatalf("invalid fault %q: %v\n", f, err)
 		}
-		fmt.Fprint(res, string(outgoingJSON))
-	}
-}
-func syncService(res http.ResponseWriter, req *http.Request) {
-	res.Header().Set("Content-Type", "application/json")
-	vars := mux.Vars(req)
-	name := vars["name"]
-
-	newSvc := lbcontroller.Service{}
-	decoder := json.NewDecoder(req.Body)
-	error := decoder.Decode(&newSvc)
-	if error != nil {
-		log.Println(error.Error())
-		http.Error(res, error.Error(), http.StatusInternalServerError)
-		return
-	}
-	if name != newSvc.Metadata.Name {
-		err := errors.Errorf("Name of service inconsistent expected %s got %s\n", name, newSvc.Metadata.Name)
-		log.Printf("%v\n", err)
-		http.Error(res, error.Error(), http.StatusInternalServerError)
-		return
-	}
-	now := time.Now()
-	_, present := services[name]
-	if present {
-		//err := errors.Errorf("Service %s already present, updating\n", newSvc.Metadata.Name)
-		//log.Println(err)
-		log.Printf("Service %s already present, updating\n", newSvc.Metadata.Name)
-		newSvc.Metadata.UpdatedAt = now
-		//http.Error(res, err.Error(), http.StatusInternalServerError)
-		//return
-	} else {
-		newSvc.Metadata.CreatedAt = now
-		newSvc.Metadata.UpdatedAt = now
-	}
-	services[name] = newSvc
-	location := "http://" + req.Host + "/ingress"
-	res.Header().Add("Location", location)
-	//res.WriteHeader(http.StatusNoContent)
-	if !present {
-		res.WriteHeader(http.StatusCreated)
 	}
 
-}
-
-func deleteService(res http.ResponseWriter, req *http.Request) {
-	res.Header().Set("Content-Type", "application/json")
-	vars := mux.Vars(req)
-	name := vars["name"]
-
-	delete(services, name)
-	res.WriteHeader(http.StatusNoContent)
-
-}
-
-func getIngress(res http.ResponseWriter, req *http.Request) {
-	res.Header().Set("Content-Type", "application/json")
-
-	ret := `[
-		{
-		 "ip": "127.0.0.1",
-		 "hostname": "ingress.host.com"
-		}   
-	 ]`
-
-	fmt.Fprint(res, ret)
+	loggedRouter := handlers.LoggingHandler(os.Stdout, api)
+	log.Fatal(http.ListenAndServe(*listen, loggedRouter))
 }
@@ -0,0 +1,51 @@
+package lbcontrollertest
+
+import (
+	"net/http/httptest"
+	"reflect"
+	"testing"
+
+	"github.com/UNINETT/lbcontroller/lbapi"
+)
+
+//Server is a mock API listening on a local httptest.Server,
+//use its URL as endpoint of the API.
+type Server struct {
+	*httptest.Server
+	*API
+}
+
+//NewServer starts and returns a new Server, the caller should call Close when finished.
+//It panics if opts are invalid.
+func NewServer(opts Options) *Server {
+	api, err := New(opts)
+	if err != nil {
+		panic("lbcontrollertest: " + err.Error())
+	}
+	return &Server{
+		Server: httptest.NewServer(api),
+		API:    api,
+	}
+}
+
+//AssertService fails the test if the service is not stored in the API
+//or if its configuration differs from want.
+func (s *Server) AssertService(t testing.TB, name string, want lbapi.Config) {
+	t.Helper()
+	got, present := s.Service(name)
+	if !present {
+		t.Errorf("service %s not found in the API", name)
+		return
+	}
+	if !reflect.DeepEqual(got.Config, want) {
+		t.Errorf("service %s config = %+v, want %+v", name, got.Config, want)
+	}
+}
+
+//AssertNoService fails the test if the service is stored in the API
+func (s *Server) AssertNoService(t testing.TB, name string) {
+	t.Helper()
+	if _, present := s.Service(name); present {
+		t.Errorf("service %s found in the API", name)
+	}
+}
@@ -0,0 +1,291 @@
+package lbcontrollertest
+
+import (
+	"io/ioutil"
+	"log"
+	"net"
+	"os"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/UNINETT/lbcontroller/ippool"
+	"github.com/UNINETT/lbcontroller/lbapi"
+	"github.com/koki/json"
+	"github.com/pkg/errors"
+	"k8s.io/api/core/v1"
+)
+
+//errNoIngress is returned when the ingress network is exhausted
+var errNoIngress = errors.New("no free ingress IP left")
+
+//store keeps the services configured on the mock, optionally persisted in a file
+type store struct {
+	mu        sync.Mutex
+	file      string
+	pools     []pool
+	domain    string
+	Services  map[string]lbapi.Service  `json:"services"`
+	Frontends map[string]lbapi.Frontend `json:"frontends,omitempty"`
+}
+
+//pool is a pool of ingress IPs
+type pool struct {
+	lbapi.Pool
+	ips *ippool.Pool
+}
+
+//loadStore returns a store with the services persisted in file, if any
+func loadStore(file string, pools []pool, domain string) (*store, error) {
+	s := &store{
+		file:      file,
+		pools:     pools,
+		domain:    domain,
+		Services:  map[string]lbapi.Service{},
+		Frontends: map[string]lbapi.Frontend{},
+	}
+	if file == "" {
+		return s, nil
+	}
+	data, err := ioutil.ReadFile(file)
+	if os.IsNotExist(err) {
+		return s, nil
+	}
+	if err != nil {
+		return nil, errors.Wrapf(err, "error reading data file %s", file)
+	}
+	if err := json.Unmarshal(data, s); err != nil {
+		return nil, errors.Wrapf(err, "error decoding data file %s", file)
+	}
+	if s.Frontends == nil {
+		s.Frontends = map[string]lbapi.Frontend{}
+	}
+	log.Printf("loaded %d services from %s\n", len(s.Services), file)
+	return s, nil
+}
+
+//save persists the services, the caller must hold the lock
+func (s *store) save() error {
+	if s.file == "" {
+		return nil
+	}
+	data, err := json.MarshalIndent(s, "", "\t")
+	if err != nil {
+		return errors.Wrap(err, "error encoding services")
+	}
+	tmp := s.file + ".tmp"
+	if err := ioutil.WriteFile(tmp, data, 0644); err != nil {
+		return errors.Wrapf(err, "error writing data file %s", tmp)
+	}
+	return errors.Wrapf(os.Rename(tmp, s.file), "error writing data file %s", s.file)
+}
+
+func (s *store) get(name string) (lbapi.Service, bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	svc, present := s.Services[name]
+	return svc, present
+}
+
+func (s *store) list() []lbapi.Service {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	ret := make([]lbapi.Service, 0, len(s.Services))
+	for _, svc := range s.Services {
+		ret = append(ret, svc)
+	}
+	sort.Slice(ret, func(i, j int) bool { return ret[i].Metadata.Name < ret[j].Metadata.Name })
+	return ret
+}
+
+//put creates or updates a service, allocating its ingress, see ingress.
+//created is true if the service was not present.
+func (s *store) put(svc lbapi.Service) (created bool, err error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	name := svc.Metadata.Name
+	now := time.Now().UTC()
+	old, present := s.Services[name]
+	var current []v1.LoadBalancerIngress
+	if present {
+		log.Printf("Service %s already present, updating\n", name)
+		svc.Metadata.CreatedAt = old.Metadata.CreatedAt
+		current = old.Ingress
+		svc.Connections = old.Connections
+	} else {
+		svc.Connections = nil
+		svc.Metadata.CreatedAt = now
+	}
+	svc.Ingress, err = s.ingress(svc, current)
+	if err != nil {
+		return false, err
+	}
+	svc.Metadata.UpdatedAt = now
+	s.Services[name] = svc
+	return !present, s.save()
+}
+
+//setConnections sets the live connections of host in the service name
+func (s *store) setConnections(name, host string, n int) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	svc, present := s.Services[name]
+	if !present {
+		return errors.Errorf("service %s not found", name)
+	}
+	conns := map[string]int{}
+	for h, c := range svc.Connections {
+		conns[h] = c
+	}
+	conns[host] = n
+	svc.Connections = conns
+	s.Services[name] = svc
+	return s.save()
+}
+
+func (s *store) delete(name string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	delete(s.Services, name)
+	return s.save()
+}
+
+//pool returns the pool of the ingress IPs of svc, according to the namespace
+//and the class in its labels. The caller must hold the lock.
+func (s *store) pool(svc lbapi.Service) (pool, error) {
+	namespace, class := svc.Metadata.Labels[lbapi.LabelNamespace], svc.Metadata.Labels[lbapi.LabelClass]
+	configs := make([]lbapi.Pool, len(s.pools))
+	for i, p := range s.pools {
+		configs[i] = p.Pool
+	}
+	selected, ok := lbapi.SelectPool(configs, namespace, class)
+	if !ok {
+		return pool{}, errors.Wrapf(errNoIngress, "no pool for namespace %q and class %q", namespace, class)
+	}
+	for _, p := range s.pools {
+		if p.Name == selected.Name {
+			return p, nil
+		}
+	}
+	return pool{}, errors.Errorf("pool %s not found", selected.Name)
+}
+
+//ingress returns the ingress of svc, one IP per family of its configuration, or
+//of the first family of its pool if none. The current IPs are kept, unless
+//another requested IP is free. New IPs are allocated from the pool of svc,
+//the requested one if free, as the real API assigns another IP if the
+//requested one is taken. The caller must hold the lock.
+func (s *store) ingress(svc lbapi.Service, current []v1.LoadBalancerIngress) ([]v1.LoadBalancerIngress, error) {
+	p, err := s.pool(svc)
+	if err != nil {
+		return nil, err
+	}
+	families := svc.Config.IPFamilies
+	if len(families) == 0 {
+		families = p.ips.Families()[:1]
+	}
+	used := s.used(svc.Metadata.Name)
+	ret := []v1.LoadBalancerIngress{}
+	for _, family := range families {
+		ip := ""
+		for _, in := range current {
+			if cur := net.ParseIP(in.IP); cur != nil && ippool.Family(cur) == family {
+				ip = cur.String()
+			}
+		}
+		requested := []string{}
+		for _, r := range svc.Config.RequestedIPs {
+			if rip := net.ParseIP(r); rip != nil && ippool.Family(rip) == family {
+				requested = append(requested, rip.String())
+			}
+		}
+		if ip == "" || (len(requested) > 0 && !contains(requested, ip)) {
+			newIP, err := p.ips.Allocate(family, used, requested)
+			if err == nil && (ip == "" || contains(requested, newIP)) {
+				ip = newIP
+			}
+		}
+		if ip == "" {
+			return nil, errors.Wrapf(errNoIngress, "pool %s, family %s", p.Name, family)
+		}
+		ret = append(ret, v1.LoadBalancerIngress{IP: ip, Hostname: svc.Metadata.Name + "." + s.domain})
+	}
+	return ret, nil
+}
+
+//used returns the ingress IPs of the services but name, the caller must hold the lock
+func (s *store) used(name string) map[string]bool {
+	used := map[string]bool{}
+	for other, svc := range s.Services {
+		if other == name {
+			continue
+		}
+		for _, in := range svc.Ingress {
+			used[in.IP] = true
+		}
+	}
+	return used
+}
+
+func contains(list []string, s string) bool {
+	for _, e := range list {
+		if e == s {
+			return true
+		}
+	}
+	return false
+}
+
+func (s *store) getFrontend(name string) (lbapi.Frontend, bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	f, present := s.Frontends[name]
+	return f, present
+}
+
+func (s *store) listFrontends() []lbapi.Frontend {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	ret := make([]lbapi.Frontend, 0, len(s.Frontends))
+	for _, f := range s.Frontends {
+		ret = append(ret, f)
+	}
+	sort.Slice(ret, func(i, j int) bool { return ret[i].Metadata.Name < ret[j].Metadata.Name })
+	return ret
+}
+
+//putFrontend creates or updates a frontend, created is true if the frontend was not present.
+func (s *store) putFrontend(f lbapi.Frontend) (created bool, err error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	now := time.Now().UTC()
+	old, present := s.Frontends[f.Metadata.Name]
+	if present {
+		f.Metadata.CreatedAt = old.Metadata.CreatedAt
+	} else {
+		f.Metadata.CreatedAt = now
+	}
+	f.Metadata.UpdatedAt = now
+	s.Frontends[f.Metadata.Name] = f
+	return !present, s.save()
+}
+
+//poolsUsage returns the pools with their usage
+func (s *store) poolsUsage() []lbapi.Pool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	used := s.used("")
+	ret := []lbapi.Pool{}
+	for _, p := range s.pools {
+		cfg := p.Pool
+		cfg.Usage = nil
+		for _, family := range p.ips.Families() {
+			size, inUse := p.ips.Usage(family, used)
+			cfg.Usage = append(cfg.Usage, lbapi.PoolUsage{Family: family, Size: size, Used: inUse})
+		}
+		ret = append(ret, cfg)
+	}
+	return ret
+}